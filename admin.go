@@ -0,0 +1,141 @@
+package awsnameserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// AdminAPI serves the cache contents and forces refreshes over HTTP, for
+// clients presenting one of tokens as a bearer token.
+type AdminAPI struct {
+	domain string
+	caches *cache.CacheSet
+	tokens []string
+}
+
+// NewAdminAPI creates the admin API for caches, accepting tokens.
+func NewAdminAPI(domain string, caches *cache.CacheSet, tokens []string) *AdminAPI {
+	return &AdminAPI{domain: domain, caches: caches, tokens: tokens}
+}
+
+// ListenAndServe serves the admin API on address.
+func (api *AdminAPI) ListenAndServe(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/records", api.authorized(api.handleRecords))
+	mux.HandleFunc("/refresh", api.authorized(api.handleRefresh))
+	mux.HandleFunc("/status", api.authorized(api.handleStatus))
+	mux.HandleFunc("/collisions", api.authorized(api.handleCollisions))
+	log.Fatalf("%s", http.ListenAndServe(address, mux))
+}
+
+// authorized only passes on requests with a valid bearer token.
+func (api *AdminAPI) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		header := req.Header.Get("Authorization")
+		if token := strings.TrimPrefix(header, "Bearer "); token != header && token != "" {
+			for _, valid := range api.tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(valid)) == 1 {
+					handler(w, req)
+					return
+				}
+			}
+		}
+		log.Printf("WARN: refusing admin request from %s: bad token", req.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}
+}
+
+// handleRecords dumps the caches as JSON. ?account= limits it to one
+// account's nickname, ?region= to one region and ?tag= to the names under
+// one subdomain such as role or lb, or name for <name>.<domain>.
+func (api *AdminAPI) handleRecords(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	caches := api.matching(query.Get("account"), query.Get("region"))
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(dumpCaches(caches, api.domain, query.Get("tag")))
+}
+
+// handleRefresh wakes the caches for refreshing, all of them or those
+// matching ?account= and ?region=. The refreshes happen in the background.
+func (api *AdminAPI) handleRefresh(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	caches := api.matching(query.Get("account"), query.Get("region"))
+	if len(caches) == 0 {
+		http.Error(w, "no such account", http.StatusNotFound)
+		return
+	}
+	for _, c := range caches {
+		log.Printf("Refreshing %s account in %s on admin request", c.Nickname(), c.Account().Region)
+		c.Wake()
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleStatus reports how each account and region's refreshes are going
+// as JSON, see cache.Status. ?account= and ?region= limit it as for
+// /records.
+func (api *AdminAPI) handleStatus(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	statuses := []cache.Status{}
+	for _, c := range api.matching(query.Get("account"), query.Get("region")) {
+		statuses = append(statuses, c.Status())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(statuses)
+}
+
+// handleCollisions lists the names that different tag values sanitized to
+// in each account and region's last refresh as JSON, see cache.Collision.
+// ?account= and ?region= limit it as for /records.
+func (api *AdminAPI) handleCollisions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := req.URL.Query()
+	collisions := []cache.Collision{}
+	for _, c := range api.matching(query.Get("account"), query.Get("region")) {
+		collisions = append(collisions, c.Collisions()...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(collisions)
+}
+
+// matching returns the caches for account and region, either of which
+// matches any if empty.
+func (api *AdminAPI) matching(account string, region string) []*cache.Cache {
+	var caches []*cache.Cache
+	for _, c := range api.caches.All() {
+		if (account == "" || c.Nickname() == account) && (region == "" || c.Account().Region == region) {
+			caches = append(caches, c)
+		}
+	}
+	return caches
+}
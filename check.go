@@ -0,0 +1,51 @@
+package awsnameserver
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// check discovers every account and region once and prints the number of
+// names found in each, or why it failed. It returns whether all succeeded.
+func check(accounts []*cache.AWSAccount, domain string, concurrency int) bool {
+	caches, errs, err := cache.Discover(accounts, domain, concurrency)
+	if err != nil {
+		fmt.Printf("FAILED: %s\n", err)
+		return false
+	}
+
+	ok := true
+	for i, c := range caches.All() {
+		if errs[i] != nil {
+			fmt.Printf("FAILED  %s account in %s: %s\n", c.Nickname(), c.Account().Region, errs[i])
+			ok = false
+			continue
+		}
+		fmt.Printf("OK      %s account in %s: %d names\n", c.Nickname(), c.Account().Region, c.Size())
+	}
+	return ok
+}
+
+// discoverOrExit discovers every account and region once for the dump and
+// query commands. Failures are reported on stderr, and exit if every
+// account failed.
+func discoverOrExit(accounts []*cache.AWSAccount, domain string, concurrency int) *cache.CacheSet {
+	caches, errs, err := cache.Discover(accounts, domain, concurrency)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED: %s\n", err)
+		os.Exit(1)
+	}
+	failed := 0
+	for i, c := range caches.All() {
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "FAILED  %s account in %s: %s\n", c.Nickname(), c.Account().Region, errs[i])
+			failed++
+		}
+	}
+	if failed > 0 && failed == len(errs) {
+		os.Exit(1)
+	}
+	return caches
+}
@@ -0,0 +1,7 @@
+package main
+
+import awsnameserver "github.com/foreflight/aws-name-server"
+
+func main() {
+	awsnameserver.Main()
+}
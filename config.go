@@ -0,0 +1,202 @@
+package awsnameserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+	"github.com/foreflight/aws-name-server/pkg/dnsserver"
+)
+
+// Config is the contents of --configFile. For backwards compatibility the
+// file may also be a bare JSON array of AWSAccount structs.
+type Config struct {
+	Accounts []*cache.AWSAccount
+
+	// TSIGKeys are the keys clients may sign queries with.
+	TSIGKeys []dnsserver.TSIGKey
+	// RequireTSIG refuses any query that isn't signed with one of TSIGKeys.
+	RequireTSIG bool
+
+	// AllowCIDRs refuses queries from clients outside these subnets.
+	AllowCIDRs []string
+
+	// ReverseCIDRs are subnets to answer reverse lookups in, as well as
+	// those of the accounts' VPCs, e.g. for instances in peered VPCs.
+	ReverseCIDRs []string
+
+	// Views choose between private and public answers by client subnet.
+	Views []*dnsserver.View
+
+	// Wildcards maps a subdomain to the Name whose instances answer for
+	// everything under it, e.g. {"api": "api-fleet"} resolves *.api.<domain>.
+	Wildcards map[string]string
+
+	// CNAMEs maps names in the domain to the targets they are answered with,
+	// e.g. {"api-gateway": "abc123.execute-api.us-east-1.amazonaws.com"}.
+	CNAMEs map[string]string
+
+	// StaticRecords are served alongside the discovered records, for hosts
+	// outside AWS, e.g. {"Name": "vpn", "Type": "A", "Value": "10.1.2.3"}.
+	StaticRecords []*dnsserver.StaticRecord
+
+	// LookupTags maps extra instance tags to the subdomain they are served
+	// under, e.g. {"Team": "team"} serves Team=infra as infra.team.<domain>.
+	LookupTags map[string]string
+
+	// IncludeTags and ExcludeTags limit discovery to the instances tagged
+	// with every one of IncludeTags and none of ExcludeTags, e.g.
+	// {"Environment": "prod"} and {"dns": "ignore"}. Accounts may set their own.
+	IncludeTags map[string]string
+	ExcludeTags map[string]string
+
+	// VPCs gives VPC ids nicknames, e.g. {"vpc-0123abcd": "prod"} serves
+	// web.vpc-prod.<domain> for the instances named web in that VPC.
+	VPCs map[string]string
+
+	// GossipKey is the base64 encoded 16, 24 or 32 byte AES key that
+	// encrypts --gossipAddress traffic, required to gossip.
+	GossipKey string
+
+	// SOA sets the SOA record's timers, hostmaster and serial strategy.
+	SOA dnsserver.SOASettings
+
+	// AdminTokens are the bearer tokens accepted by the --adminAddress API.
+	AdminTokens []string
+
+	// Flags are the settings in a YAML config file named after flags, such
+	// as domain or ttl, see applyFlags.
+	Flags map[string]string `json:"-"`
+}
+
+func getConfig(configFile *string) *Config {
+	data, err := ioutil.ReadFile(*configFile)
+	if err != nil {
+		log.Printf("WARN: %s", err)
+		return &Config{}
+	}
+
+	config, err := parseConfigFile(*configFile, data)
+	if err != nil {
+		log.Fatalf("FATAL: %s", err)
+	}
+
+	return config
+}
+
+// LoadConfig reads the config file at path, JSON or YAML as for
+// --configFile. Settings named after flags are left in Flags.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigFile(path, data)
+}
+
+// parseConfigFile reads the contents of the config file at path, which is
+// YAML if it ends in .yaml or .yml and JSON otherwise.
+func parseConfigFile(path string, data []byte) (*Config, error) {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return parseYAMLConfig(data)
+	}
+	return parseConfig(data)
+}
+
+// parseYAMLConfig reads a YAML config file. Top level keys named after a
+// flag, such as domain, listenAddress or ttl, set that flag; lists set
+// comma separated flags. The rest are the same as in the JSON config, in
+// any case, e.g. accounts or lookupTags.
+func parseYAMLConfig(data []byte) (*Config, error) {
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+
+	flags := make(map[string]string)
+	for name, value := range settings {
+		if name == "configFile" || flag.Lookup(name) == nil {
+			continue
+		}
+		if list, ok := value.([]interface{}); ok {
+			values := make([]string, len(list))
+			for i, item := range list {
+				values[i] = fmt.Sprint(item)
+			}
+			flags[name] = strings.Join(values, ",")
+		} else {
+			flags[name] = fmt.Sprint(value)
+		}
+		delete(settings, name)
+	}
+
+	// the rest decode the same as JSON, so Durations and field names match
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	config.Flags = flags
+	return config, nil
+}
+
+// applyFlags sets the flags from the config file that weren't given on the
+// command line, which override the config file.
+func (config *Config) applyFlags() error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for name, value := range config.Flags {
+		if given[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// flagGiven returns whether the flag name was set, on the command line or
+// by the config file.
+func flagGiven(name string) bool {
+	given := false
+	flag.Visit(func(f *flag.Flag) {
+		given = given || f.Name == name
+	})
+	return given
+}
+
+// parseConfig reads the contents of a JSON --configFile.
+func parseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	var err error
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &config.Accounts)
+	} else {
+		err = json.Unmarshal(data, config)
+	}
+	if err != nil {
+		return config, err
+	}
+	// an empty token would let requests without one in
+	for _, token := range config.AdminTokens {
+		if strings.TrimSpace(token) == "" {
+			return config, fmt.Errorf("AdminTokens can't be empty")
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,143 @@
+package awsnameserver
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// CONSUL_SERVICE_PREFIX starts the ids of the services ConsulSync
+// registers, so it only ever deregisters its own.
+const CONSUL_SERVICE_PREFIX = "aws-name-server-"
+
+// ConsulSync registers each instance in the caches with the Consul catalog
+// as an external node, named after its instance id, with a service named
+// after its Name tag. The service meta has the instance id, account and
+// availability zone.
+type ConsulSync struct {
+	client *consul.Client
+	caches *cache.CacheSet
+	mutex  sync.Mutex
+
+	registered map[string]*consul.CatalogRegistration
+}
+
+// NewConsulSync creates a ConsulSync for the Consul agent at address, which
+// pushes the caches to it after every refresh. The usual CONSUL_HTTP_TOKEN
+// and other environment variables are honoured.
+func NewConsulSync(address string, caches *cache.CacheSet) (*ConsulSync, error) {
+	config := consul.DefaultConfig()
+	config.Address = address
+	client, err := consul.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	c := &ConsulSync{
+		client:     client,
+		caches:     caches,
+		registered: make(map[string]*consul.CatalogRegistration),
+	}
+	caches.Subscribe(c.push)
+	return c, nil
+}
+
+// push registers the services that are new or changed since the last push,
+// and deregisters those that have gone, and their nodes.
+func (c *ConsulSync) push() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	registrations := consulRegistrations(c.caches.All())
+	catalog := c.client.Catalog()
+	changes := 0
+	for id, registration := range registrations {
+		if old, ok := c.registered[id]; ok && reflect.DeepEqual(old, registration) {
+			continue
+		}
+		if _, err := catalog.Register(registration, nil); err != nil {
+			log.Printf("ERROR: registering %s with Consul: %s", id, err)
+			continue
+		}
+		c.registered[id] = registration
+		changes++
+	}
+
+	nodes := make(map[string]bool)
+	for _, registration := range registrations {
+		nodes[registration.Node] = true
+	}
+	for id, old := range c.registered {
+		if _, ok := registrations[id]; ok {
+			continue
+		}
+		deregistration := &consul.CatalogDeregistration{Node: old.Node, ServiceID: id}
+		if !nodes[old.Node] {
+			// the instance has gone, so take its node with it
+			deregistration.ServiceID = ""
+		}
+		if _, err := catalog.Deregister(deregistration, nil); err != nil {
+			log.Printf("ERROR: deregistering %s from Consul: %s", id, err)
+			continue
+		}
+		delete(c.registered, id)
+		changes++
+	}
+	if changes > 0 {
+		log.Printf("Pushed %d changes to Consul", changes)
+	}
+}
+
+// consulRegistrations builds the catalog registrations for the instances in
+// caches, by service id.
+func consulRegistrations(caches []*cache.Cache) map[string]*consul.CatalogRegistration {
+	registrations := make(map[string]*consul.CatalogRegistration)
+	for _, c := range caches {
+		for key, records := range c.Records() {
+			if key.LookupTag != cache.LOOKUP_NAME {
+				continue
+			}
+			for _, record := range records {
+				if !strings.HasPrefix(record.InstanceID, "i-") || record.PrivateIP == nil || record.CName != "" {
+					continue
+				}
+				id := CONSUL_SERVICE_PREFIX + record.InstanceID + "-" + key.Value
+				meta := map[string]string{
+					"instance-id": record.InstanceID,
+					"account":     c.Nickname(),
+					"region":      c.Account().Region,
+				}
+				if record.AvailabilityZone != "" {
+					meta["availability-zone"] = record.AvailabilityZone
+				}
+				if record.VpcID != "" {
+					meta["vpc-id"] = record.VpcID
+				}
+				var tags []string
+				for service := range record.Services {
+					tags = append(tags, service)
+				}
+				sort.Strings(tags)
+				registrations[id] = &consul.CatalogRegistration{
+					Node:     record.InstanceID,
+					Address:  record.PrivateIP.String(),
+					NodeMeta: map[string]string{"external-node": "true", "external-probe": "false"},
+					Service: &consul.AgentService{
+						ID:      id,
+						Service: key.Value,
+						Tags:    tags,
+						Meta:    meta,
+						Port:    int(record.Port),
+						Address: record.PrivateIP.String(),
+					},
+				}
+			}
+		}
+	}
+	return registrations
+}
@@ -0,0 +1,47 @@
+package awsnameserver
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// listenAndServeDebug serves net/http/pprof under /debug/pprof/ and expvar
+// (including runtime.MemStats and the size of each of caches) under
+// /debug/vars on address, which must be on the loopback interface as
+// profiles expose the server's internals.
+func listenAndServeDebug(address string, caches *cache.CacheSet) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("--debugAddress %s must be on localhost", address)
+	}
+
+	expvar.Publish("caches", expvar.Func(func() interface{} {
+		sizes := make(map[string]int)
+		for _, c := range caches.All() {
+			sizes[c.Nickname()+"/"+c.Account().Region] = c.Size()
+		}
+		return sizes
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	go func() {
+		log.Fatalf("%s", http.ListenAndServe(address, mux))
+	}()
+	return nil
+}
@@ -0,0 +1,72 @@
+package awsnameserver
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// DUMP_FORMATS are the formats the dump command prints, see --format.
+var DUMP_FORMATS = []string{"json", "zonefile", "hosts"}
+
+// CacheDump is one account and region in a dump of the records, as printed
+// by the dump command and the admin API's GET /records.
+type CacheDump struct {
+	Account   string
+	Region    string
+	Refreshed time.Time
+	Stale     bool
+	Names     []*NameDump
+}
+
+// NameDump is a name served from a cache and the records it answers with.
+type NameDump struct {
+	Name    string
+	Records []*cache.Record
+}
+
+// dumpCaches lists the names served from caches under domain, sorted. If
+// tag isn't empty only the names under that subdomain are listed, see
+// keyHasTag.
+func dumpCaches(caches []*cache.Cache, domain string, tag string) []*CacheDump {
+	dumps := []*CacheDump{}
+	for _, c := range caches {
+		dump := &CacheDump{
+			Account:   c.Nickname(),
+			Region:    c.Account().Region,
+			Refreshed: c.Refreshed(),
+			Stale:     c.Staleness() > 0,
+			Names:     []*NameDump{},
+		}
+		for key, records := range c.Records() {
+			if tag != "" && !keyHasTag(key, tag) {
+				continue
+			}
+			dump.Names = append(dump.Names, &NameDump{Name: cache.KeyName(key, domain), Records: records})
+		}
+		sort.Slice(dump.Names, func(i, j int) bool {
+			return dump.Names[i].Name < dump.Names[j].Name
+		})
+		dumps = append(dumps, dump)
+	}
+	return dumps
+}
+
+// keyHasTag returns whether key is served under tag's subdomain, with
+// "name" standing for the top level.
+func keyHasTag(key cache.Key, tag string) bool {
+	if key.LookupTag == cache.LOOKUP_NAME || key.LookupTag == cache.LOOKUP_WILDCARD {
+		return tag == "name"
+	}
+	return cache.SubdomainOf(key.LookupTag) == tag
+}
+
+// printJSON prints v to stdout as indented JSON.
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
@@ -0,0 +1,193 @@
+package awsnameserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// EVENT_WAIT is how long each SQS long poll waits for events.
+const EVENT_WAIT = 20 * time.Second
+
+// Event is the envelope EventBridge wraps every event in. Only the fields
+// needed to find the affected caches are decoded.
+type Event struct {
+	DetailType string          `json:"detail-type"`
+	Source     string          `json:"source"`
+	Account    string          `json:"account"`
+	Region     string          `json:"region"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// EventListener refreshes caches as soon as EventBridge reports a change in
+// their account and region, such as an EC2 instance state change or tag
+// change, via an SQS queue. Polling carries on as a fallback that catches
+// anything the events miss.
+type EventListener struct {
+	queueURL string
+	client   *sqs.Client
+	caches   *cache.CacheSet
+}
+
+// NewEventListener creates an EventListener for the SQS queue at queueURL,
+// e.g. https://sqs.us-east-1.amazonaws.com/123456789012/aws-name-server.
+func NewEventListener(queueURL string, caches *cache.CacheSet) (*EventListener, error) {
+	region, err := queueRegion(queueURL)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+	return &EventListener{
+		queueURL: queueURL,
+		client:   sqs.NewFromConfig(cfg),
+		caches:   caches,
+	}, nil
+}
+
+// queueRegion is the region in an SQS queue URL, https://sqs.<region>.amazonaws.com/...
+func queueRegion(queueURL string) (string, error) {
+	parsed, err := url.Parse(queueURL)
+	if err != nil {
+		return "", err
+	}
+	labels := strings.Split(parsed.Hostname(), ".")
+	if len(labels) < 3 || labels[0] != "sqs" {
+		return "", fmt.Errorf("can't find the region in SQS queue URL %q", queueURL)
+	}
+	return labels[1], nil
+}
+
+// Listen receives events until the process exits.
+func (listener *EventListener) Listen() {
+	for {
+		output, err := listener.client.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(listener.queueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     int32(EVENT_WAIT / time.Second),
+		})
+		if err != nil {
+			log.Printf("ERROR: receiving events from %s: %s", listener.queueURL, err)
+			time.Sleep(EVENT_WAIT)
+			continue
+		}
+
+		var done []sqstypes.DeleteMessageBatchRequestEntry
+		for _, message := range output.Messages {
+			listener.receive(aws.ToString(message.Body))
+			done = append(done, sqstypes.DeleteMessageBatchRequestEntry{
+				Id:            message.MessageId,
+				ReceiptHandle: message.ReceiptHandle,
+			})
+		}
+		if len(done) == 0 {
+			continue
+		}
+		if _, err := listener.client.DeleteMessageBatch(context.Background(), &sqs.DeleteMessageBatchInput{
+			QueueUrl: aws.String(listener.queueURL),
+			Entries:  done,
+		}); err != nil {
+			log.Printf("ERROR: deleting events from %s: %s", listener.queueURL, err)
+		}
+	}
+}
+
+// receive handles a message from the queue, either an EventBridge event or
+// a lifecycle hook notification sent straight to SQS.
+func (listener *EventListener) receive(body string) {
+	var event Event
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		log.Printf("WARN: ignoring event that isn't JSON: %s", err)
+		return
+	}
+	if event.DetailType == "" {
+		var action lifecycleAction
+		if json.Unmarshal([]byte(body), &action) == nil && action.LifecycleTransition == "autoscaling:EC2_INSTANCE_TERMINATING" {
+			listener.terminating(action.EC2InstanceId)
+		}
+		return
+	}
+	if id := terminatingInstance(&event); id != "" {
+		listener.terminating(id)
+		return
+	}
+	listener.handle(&event)
+}
+
+// terminating removes an instance that is about to terminate from whichever
+// cache has it, without waiting for a refresh.
+func (listener *EventListener) terminating(instanceID string) {
+	for _, c := range listener.caches.All() {
+		if c.Terminating(instanceID) {
+			return
+		}
+	}
+}
+
+// handle wakes the caches that event may have changed.
+func (listener *EventListener) handle(event *Event) {
+	for _, c := range listener.caches.All() {
+		if c.Account().Region != event.Region {
+			continue
+		}
+		if id := accountID(c.Account()); id != "" && id != event.Account {
+			continue
+		}
+		log.Printf("%s event from %s in %s, refreshing %s account", event.DetailType, event.Account, event.Region, c.Nickname())
+		c.Wake()
+	}
+}
+
+// accountID is the AWS account id from account's role ARN, or "" if it
+// isn't known.
+func accountID(account cache.AWSAccount) string {
+	// arn:aws:iam::123456789012:role/AWSNameServer
+	if parts := strings.Split(account.Arn, ":"); len(parts) > 4 {
+		return parts[4]
+	}
+	return ""
+}
+
+// lifecycleAction is the detail of an EC2 Instance-terminate Lifecycle
+// Action event, and also the body of lifecycle hook notifications sent
+// straight to SQS.
+type lifecycleAction struct {
+	LifecycleTransition string
+	EC2InstanceId       string
+}
+
+// spotInterruption is the detail of an EC2 Spot Instance Interruption Warning.
+type spotInterruption struct {
+	InstanceID string `json:"instance-id"`
+}
+
+// terminatingInstance returns the id of the instance event says is about to
+// terminate, or "" if it isn't such an event.
+func terminatingInstance(event *Event) string {
+	switch event.DetailType {
+	case "EC2 Instance-terminate Lifecycle Action":
+		var detail lifecycleAction
+		if json.Unmarshal(event.Detail, &detail) == nil {
+			return detail.EC2InstanceId
+		}
+	case "EC2 Spot Instance Interruption Warning":
+		var detail spotInterruption
+		if json.Unmarshal(event.Detail, &detail) == nil {
+			return detail.InstanceID
+		}
+	}
+	return ""
+}
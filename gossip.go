@@ -0,0 +1,127 @@
+package awsnameserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// GOSSIP_PORT is the port --gossipAddress and --gossipJoin default to.
+const GOSSIP_PORT = 7946
+
+// GOSSIP_LEAVE_TIMEOUT is how long a server shutting down waits for its
+// leaving to reach the rest of the cluster.
+const GOSSIP_LEAVE_TIMEOUT = 5 * time.Second
+
+// Gossip shares the records in the caches with the other servers in a
+// memberlist cluster. Each member sends the others its caches in full,
+// every --refreshInterval with a random peer and whenever one joins, and
+// serves theirs in place of any it can't refresh itself, see
+// CacheSet.Replicate. Only one member per account then needs credentials.
+type Gossip struct {
+	caches *cache.CacheSet
+	list   *memberlist.Memberlist
+}
+
+// NewGossip joins the cluster of the members at join, if any, gossiping on
+// address. key is the base64 encoded AES key encrypting and authenticating
+// the gossip, which every member must share. It is required, as any host
+// that can reach address could otherwise replace the records served.
+func NewGossip(address string, join []string, key string, caches *cache.CacheSet) (*Gossip, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, strconv.Itoa(GOSSIP_PORT)
+	}
+	config := memberlist.DefaultLANConfig()
+	config.BindAddr = host
+	if config.BindPort, err = strconv.Atoi(port); err != nil {
+		return nil, fmt.Errorf("--gossipAddress %s: %s", address, err)
+	}
+	config.AdvertisePort = config.BindPort
+	// names must be unique, and hostnames aren't always
+	if hostname, err := os.Hostname(); err == nil {
+		config.Name = hostname + "-" + port
+	}
+	if key == "" {
+		return nil, fmt.Errorf("--gossipAddress needs a GossipKey in the config file")
+	}
+	if config.SecretKey, err = base64.StdEncoding.DecodeString(key); err != nil {
+		return nil, fmt.Errorf("GossipKey: %s", err)
+	}
+	config.PushPullInterval = cache.REFRESH_INTERVAL
+	config.Logger = log.Default()
+
+	gossip := &Gossip{caches: caches}
+	config.Delegate = gossip
+	if gossip.list, err = memberlist.Create(config); err != nil {
+		return nil, err
+	}
+
+	var peers []string
+	for _, peer := range join {
+		if peer = strings.TrimSpace(peer); peer == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(peer); err != nil {
+			peer = net.JoinHostPort(peer, strconv.Itoa(GOSSIP_PORT))
+		}
+		peers = append(peers, peer)
+	}
+	if len(peers) > 0 {
+		if joined, err := gossip.list.Join(peers); err != nil {
+			log.Printf("WARN: joined %d of %d gossip peers: %s", joined, len(peers), err)
+		}
+	}
+	return gossip, nil
+}
+
+// Members is how many servers are in the cluster, this one included.
+func (gossip *Gossip) Members() int {
+	return gossip.list.NumMembers()
+}
+
+// Leave tells the other members this server is going, for shutting down.
+func (gossip *Gossip) Leave() {
+	if err := gossip.list.Leave(GOSSIP_LEAVE_TIMEOUT); err != nil {
+		log.Printf("WARN: leaving the gossip cluster: %s", err)
+	}
+	gossip.list.Shutdown()
+}
+
+// LocalState is the caches, sent to a peer.
+func (gossip *Gossip) LocalState(join bool) []byte {
+	data, err := json.Marshal(gossip.caches.Snapshot())
+	if err != nil {
+		log.Printf("ERROR: gossiping the caches: %s", err)
+		return nil
+	}
+	return data
+}
+
+// MergeRemoteState serves the caches a peer sent, where they are newer.
+func (gossip *Gossip) MergeRemoteState(data []byte, join bool) {
+	snapshot := &cache.Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		log.Printf("WARN: ignoring gossiped caches: %s", err)
+		return
+	}
+	if replaced := gossip.caches.Replicate(snapshot); replaced > 0 {
+		log.Printf("Replicated %d accounts and regions from gossip", replaced)
+	}
+}
+
+// NodeMeta, NotifyMsg and GetBroadcasts complete memberlist.Delegate. The
+// caches are too large for the UDP messages broadcasts are sent in.
+func (gossip *Gossip) NodeMeta(limit int) []byte                  { return nil }
+func (gossip *Gossip) NotifyMsg(message []byte)                   {}
+func (gossip *Gossip) GetBroadcasts(overhead, limit int) [][]byte { return nil }
@@ -0,0 +1,85 @@
+package awsnameserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// HostsWriter keeps a file in /etc/hosts format up to date with the
+// caches, for machines that can't reach the DNS server.
+type HostsWriter struct {
+	path   string
+	domain string
+	caches *cache.CacheSet
+	mutex  sync.Mutex
+}
+
+// NewHostsWriter creates a HostsWriter that rewrites path after every
+// refresh of caches.
+func NewHostsWriter(path string, domain string, caches *cache.CacheSet) *HostsWriter {
+	writer := &HostsWriter{path: path, domain: domain, caches: caches}
+	caches.Subscribe(writer.write)
+	return writer
+}
+
+// write replaces the hosts file.
+func (writer *HostsWriter) write() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	var buffer bytes.Buffer
+	writeHosts(&buffer, writer.caches.All(), writer.domain)
+	if err := writeFileAtomic(writer.path, buffer.Bytes()); err != nil {
+		log.Printf("ERROR: writing %s: %s", writer.path, err)
+	}
+}
+
+// writeHosts writes a line for each private IP in caches with every name
+// it is served as under domain, in the flat namespace and under each
+// account's nickname. CNAMEs and wildcards can't be expressed, so are left
+// out.
+func writeHosts(w io.Writer, caches []*cache.Cache, domain string) {
+	domain = strings.TrimSuffix(domain, ".")
+	names := make(map[string]map[string]bool)
+	for _, c := range caches {
+		for key, records := range c.Records() {
+			if key.LookupTag == cache.LOOKUP_WILDCARD {
+				continue
+			}
+			for _, record := range records {
+				if record.CName != "" || record.PrivateIP == nil {
+					continue
+				}
+				ip := record.PrivateIP.String()
+				if names[ip] == nil {
+					names[ip] = make(map[string]bool)
+				}
+				names[ip][cache.KeyName(key, domain)] = true
+				names[ip][cache.KeyName(key, c.Nickname()+"."+domain)] = true
+			}
+		}
+	}
+
+	ips := make([]string, 0, len(names))
+	for ip := range names {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+
+	fmt.Fprintf(w, "# generated by aws-name-server for %s, do not edit\n", domain)
+	for _, ip := range ips {
+		list := make([]string, 0, len(names[ip]))
+		for name := range names[ip] {
+			list = append(list, name)
+		}
+		sort.Strings(list)
+		fmt.Fprintf(w, "%s\t%s\n", ip, strings.Join(list, " "))
+	}
+}
@@ -0,0 +1,287 @@
+//go:build integration
+
+// Package integration runs discovery and queries end to end against an AWS
+// API emulator, LocalStack or moto, rather than AWS. Start one and point the
+// tests at it with -endpoint-url:
+//
+//	docker run -d -p 4566:4566 localstack/localstack
+//	go test -tags=integration ./integration -endpoint-url http://localhost:4566
+package integration
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/miekg/dns"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+	"github.com/foreflight/aws-name-server/pkg/dnsserver"
+	_ "github.com/foreflight/aws-name-server/pkg/providers"
+)
+
+var endpointURL = flag.String("endpoint-url", "http://localhost:4566", "URL of the LocalStack or moto server to test against")
+
+// DOMAIN is the domain the tests serve.
+const DOMAIN = "aws.example.com"
+
+// REGION is where the instances and databases are created.
+const REGION = "us-east-1"
+
+// PEER_ROLE is assumed to discover the second account. The emulators don't
+// check the role exists, and keep its resources apart from the main
+// account's.
+const PEER_ROLE = "arn:aws:iam::000000000002:role/aws-name-server"
+
+// PAGE_SIZE is the most instances each DescribeInstances returns, so the
+// web fleet takes several pages.
+const PAGE_SIZE = 5
+
+// WEB_FLEET is how many instances are named web.
+const WEB_FLEET = 12
+
+// pagedEC2 limits each DescribeInstances page to PAGE_SIZE, as the
+// emulators otherwise return every instance at once.
+type pagedEC2 struct {
+	cache.EC2API
+}
+
+func (client pagedEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	input := *params
+	input.MaxResults = aws.Int32(PAGE_SIZE)
+	return client.EC2API.DescribeInstances(ctx, &input, optFns...)
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	// the emulators accept any credentials
+	os.Setenv("AWS_ACCESS_KEY_ID", "test")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	os.Setenv("AWS_REGION", REGION)
+	cache.EC2_ENDPOINT, cache.RDS_ENDPOINT, cache.STS_ENDPOINT = *endpointURL, *endpointURL, *endpointURL
+	sdkEC2 := cache.DEFAULT_CLIENTS.EC2
+	cache.DEFAULT_CLIENTS.EC2 = func(cfg aws.Config) cache.EC2API { return pagedEC2{sdkEC2(cfg)} }
+	os.Exit(m.Run())
+}
+
+// seeder creates the resources to discover in one account.
+type seeder struct {
+	t   *testing.T
+	ctx context.Context
+	ec2 *ec2.Client
+	rds *rds.Client
+	ami string
+}
+
+// newSeeder creates a seeder for the main account, or the account of role
+// if it isn't empty.
+func newSeeder(t *testing.T, ctx context.Context, role string) *seeder {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(REGION))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != "" {
+		client := sts.NewFromConfig(cfg, func(options *sts.Options) { options.BaseEndpoint = endpointURL })
+		provider := stscreds.NewAssumeRoleProvider(client, role)
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	s := &seeder{
+		t:   t,
+		ctx: ctx,
+		ec2: ec2.NewFromConfig(cfg, func(options *ec2.Options) { options.BaseEndpoint = endpointURL }),
+		rds: rds.NewFromConfig(cfg, func(options *rds.Options) { options.BaseEndpoint = endpointURL }),
+	}
+
+	images, err := s.ec2.DescribeImages(ctx, &ec2.DescribeImagesInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images.Images) == 0 {
+		t.Fatalf("%s has no AMIs to launch instances from", *endpointURL)
+	}
+	s.ami = aws.ToString(images.Images[0].ImageId)
+	return s
+}
+
+// instances launches count instances with tags, and terminates them when
+// the test is done.
+func (s *seeder) instances(count int32, tags map[string]string) []ec2types.Instance {
+	var ec2Tags []ec2types.Tag
+	for key, value := range tags {
+		ec2Tags = append(ec2Tags, ec2types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	input := &ec2.RunInstancesInput{
+		ImageId:      aws.String(s.ami),
+		InstanceType: ec2types.InstanceTypeT3Micro,
+		MinCount:     aws.Int32(count),
+		MaxCount:     aws.Int32(count),
+	}
+	if len(ec2Tags) > 0 {
+		input.TagSpecifications = []ec2types.TagSpecification{{ResourceType: ec2types.ResourceTypeInstance, Tags: ec2Tags}}
+	}
+	output, err := s.ec2.RunInstances(s.ctx, input)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	var ids []string
+	for _, instance := range output.Instances {
+		ids = append(ids, aws.ToString(instance.InstanceId))
+	}
+	s.t.Cleanup(func() {
+		s.ec2.TerminateInstances(context.Background(), &ec2.TerminateInstancesInput{InstanceIds: ids})
+	})
+	waiter := ec2.NewInstanceRunningWaiter(s.ec2)
+	if err := waiter.Wait(s.ctx, &ec2.DescribeInstancesInput{InstanceIds: ids}, time.Minute); err != nil {
+		s.t.Fatal(err)
+	}
+	return output.Instances
+}
+
+// database creates an RDS instance named id, returning false if the
+// emulator doesn't support RDS, as the free LocalStack doesn't.
+func (s *seeder) database(id string) bool {
+	_, err := s.rds.CreateDBInstance(s.ctx, &rds.CreateDBInstanceInput{
+		DBInstanceIdentifier: aws.String(id),
+		DBInstanceClass:      aws.String("db.t3.micro"),
+		Engine:               aws.String("postgres"),
+		AllocatedStorage:     aws.Int32(20),
+		MasterUsername:       aws.String("postgres"),
+		MasterUserPassword:   aws.String("not-a-secret"),
+	})
+	if err != nil {
+		log.Printf("WARN: not testing RDS: %s", err)
+		return false
+	}
+	s.t.Cleanup(func() {
+		s.rds.DeleteDBInstance(context.Background(), &rds.DeleteDBInstanceInput{
+			DBInstanceIdentifier: aws.String(id),
+			SkipFinalSnapshot:    aws.Bool(true),
+		})
+	})
+	return true
+}
+
+// query asks server for name and returns the answers' data, sorted.
+func query(t *testing.T, server *dnsserver.NameServer, name string, qtype uint16) []string {
+	request := new(dns.Msg).SetQuestion(dns.Fqdn(name), qtype)
+	response := server.Reply(request, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	var answers []string
+	for _, rr := range response.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			answers = append(answers, rr.A.String())
+		case *dns.CNAME:
+			answers = append(answers, rr.Target)
+		default:
+			answers = append(answers, rr.String())
+		}
+	}
+	sort.Strings(answers)
+	return answers
+}
+
+// privateIPs are the sorted private IPs of instances.
+func privateIPs(instances []ec2types.Instance) []string {
+	var ips []string
+	for _, instance := range instances {
+		ips = append(ips, aws.ToString(instance.PrivateIpAddress))
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+func expect(t *testing.T, name string, got []string, want []string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s: got %v, want %v", name, got, want)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	mainAccount := newSeeder(t, ctx, "")
+	web := mainAccount.instances(WEB_FLEET, map[string]string{"Name": "web", "Role": "frontend"})
+	odd := mainAccount.instances(1, map[string]string{"Name": "Odd Name_v2.1!"})
+	api := mainAccount.instances(1, map[string]string{"Name": "*.api"})
+	untagged := mainAccount.instances(1, nil)
+	withDatabase := mainAccount.database("orders")
+
+	peer := newSeeder(t, ctx, PEER_ROLE)
+	proxy := peer.instances(2, map[string]string{"Name": "proxy"})
+
+	accounts := []*cache.AWSAccount{
+		{NickName: "main", Regions: []string{REGION}},
+		{NickName: "peer", Arn: PEER_ROLE, Regions: []string{REGION}},
+	}
+	caches, _, err := cache.NewCaches(accounts, DOMAIN, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caches.Stop()
+	for _, c := range caches.All() {
+		if c.Staleness() > 0 {
+			t.Fatalf("%s account in %s failed its first refresh", c.Nickname(), c.Account().Region)
+		}
+	}
+	server := dnsserver.NewNameServer(DOMAIN, "ns."+DOMAIN, caches)
+
+	t.Run("pagination", func(t *testing.T) {
+		expect(t, "web", query(t, server, "web."+DOMAIN, dns.TypeA), privateIPs(web))
+		expect(t, "role", query(t, server, "frontend.role."+DOMAIN, dns.TypeA), privateIPs(web))
+	})
+
+	t.Run("nth", func(t *testing.T) {
+		seen := make(map[string]bool)
+		for n := 0; n < WEB_FLEET; n++ {
+			answers := query(t, server, fmt.Sprintf("%d.web.%s", n, DOMAIN), dns.TypeA)
+			if len(answers) != 1 {
+				t.Fatalf("%d.web: got %v, want one answer", n, answers)
+			}
+			seen[answers[0]] = true
+		}
+		if len(seen) != WEB_FLEET {
+			t.Errorf("nth lookups returned %d distinct instances, want %d", len(seen), WEB_FLEET)
+		}
+		expect(t, "past the end", query(t, server, fmt.Sprintf("%d.web.%s", WEB_FLEET, DOMAIN), dns.TypeA), nil)
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		expect(t, "sanitized", query(t, server, cache.Sanitize("Odd Name_v2.1!")+"."+DOMAIN, dns.TypeA), privateIPs(odd))
+		expect(t, "wildcard", query(t, server, "anything.api."+DOMAIN, dns.TypeA), privateIPs(api))
+		id := aws.ToString(untagged[0].InstanceId)
+		expect(t, "instance id", query(t, server, id+"."+DOMAIN, dns.TypeA), privateIPs(untagged))
+		expect(t, "missing", query(t, server, "nothing."+DOMAIN, dns.TypeA), nil)
+	})
+
+	t.Run("accounts", func(t *testing.T) {
+		expect(t, "flat", query(t, server, "proxy."+DOMAIN, dns.TypeA), privateIPs(proxy))
+		expect(t, "peer", query(t, server, "proxy.peer."+DOMAIN, dns.TypeA), privateIPs(proxy))
+		expect(t, "main", query(t, server, "proxy.main."+DOMAIN, dns.TypeA), nil)
+		expect(t, "main web", query(t, server, "web.main."+DOMAIN, dns.TypeA), privateIPs(web))
+	})
+
+	t.Run("rds", func(t *testing.T) {
+		if !withDatabase {
+			t.Skip("the emulator doesn't support RDS")
+		}
+		if answers := query(t, server, "orders."+DOMAIN, dns.TypeA); len(answers) != 1 {
+			t.Errorf("orders: got %v, want one CNAME", answers)
+		}
+	})
+}
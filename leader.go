@@ -0,0 +1,183 @@
+package awsnameserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// LEADER_LEASE is how long a leader holds the lock without renewing it.
+// It renews every third of that, so a leader that stops is replaced
+// within LEADER_LEASE.
+const LEADER_LEASE = 30 * time.Second
+
+// Elector elects one of the servers sharing a DynamoDB table as the leader,
+// which alone polls AWS. The rest follow it, serving the records it
+// gossips, see CacheSet.Follow. The lock is an item keyed by LockID, the
+// table's string partition key, holding the leader's name and when its
+// lease expires. Servers take it when it is missing or has expired.
+type Elector struct {
+	table  string
+	lockID string
+	name   string
+	client *dynamodb.Client
+	caches *cache.CacheSet
+
+	// leading is whether this server holds the lock, decided whether that
+	// has been settled yet, and renewed when the lock was last renewed.
+	leading bool
+	decided bool
+	renewed time.Time
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewElector creates an Elector for the lock lockID in table, a name or
+// ARN, found in region unless the ARN says otherwise.
+func NewElector(table string, lockID string, region string, caches *cache.CacheSet) (*Elector, error) {
+	if arn := strings.Split(table, ":"); len(arn) > 3 && arn[0] == "arn" {
+		region = arn[3]
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return &Elector{
+		table:  table,
+		lockID: lockID,
+		name:   hostname + "-" + strconv.Itoa(os.Getpid()),
+		client: dynamodb.NewFromConfig(cfg),
+		caches: caches,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Run follows until it can take the lock, and leads while it can renew it,
+// until Resign.
+func (elector *Elector) Run() {
+	defer close(elector.done)
+	ticker := time.NewTicker(LEADER_LEASE / 3)
+	defer ticker.Stop()
+	for {
+		elector.campaign()
+		select {
+		case <-ticker.C:
+		case <-elector.stop:
+			return
+		}
+	}
+}
+
+// campaign takes or renews the lock, and leads or follows accordingly. A
+// leader that can't reach DynamoDB carries on until its lease runs out,
+// when another server may have taken over.
+func (elector *Elector) campaign() {
+	ctx, cancel := context.WithTimeout(context.Background(), LEADER_LEASE/3)
+	defer cancel()
+
+	now := time.Now()
+	_, err := elector.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(elector.table),
+		Item: map[string]dynamodbtypes.AttributeValue{
+			"LockID":  &dynamodbtypes.AttributeValueMemberS{Value: elector.lockID},
+			"Owner":   &dynamodbtypes.AttributeValueMemberS{Value: elector.name},
+			"Expires": &dynamodbtypes.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(LEADER_LEASE).Unix(), 10)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(LockID) OR Expires < :now OR #owner = :me"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "Owner",
+		},
+		ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{
+			":now": &dynamodbtypes.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			":me":  &dynamodbtypes.AttributeValueMemberS{Value: elector.name},
+		},
+	})
+
+	var held *dynamodbtypes.ConditionalCheckFailedException
+	switch {
+	case err == nil:
+		elector.renewed = now
+		elector.lead(true)
+	case errors.As(err, &held):
+		elector.lead(false)
+	default:
+		log.Printf("WARN: leader lock %s in %s: %s", elector.lockID, elector.table, err)
+		if elector.leading && time.Since(elector.renewed) > LEADER_LEASE {
+			elector.lead(false)
+		}
+	}
+}
+
+// lead starts or stops leading.
+func (elector *Elector) lead(leading bool) {
+	if elector.decided && leading == elector.leading {
+		return
+	}
+	elector.leading, elector.decided = leading, true
+	if leading {
+		log.Printf("Leading: polling AWS for %s", elector.lockID)
+	} else {
+		log.Printf("Following: serving the records the leader of %s gossips", elector.lockID)
+	}
+	elector.caches.Follow(!leading)
+}
+
+// Resign stops campaigning and gives up the lock if this server holds it,
+// so another can take over without waiting for the lease to run out.
+func (elector *Elector) Resign() {
+	close(elector.stop)
+	<-elector.done
+	if !elector.leading {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), LEADER_LEASE/3)
+	defer cancel()
+	_, err := elector.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(elector.table),
+		Key: map[string]dynamodbtypes.AttributeValue{
+			"LockID": &dynamodbtypes.AttributeValueMemberS{Value: elector.lockID},
+		},
+		ConditionExpression: aws.String("#owner = :me"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "Owner",
+		},
+		ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{
+			":me": &dynamodbtypes.AttributeValueMemberS{Value: elector.name},
+		},
+	})
+	if err != nil {
+		log.Printf("WARN: giving up leader lock %s: %s", elector.lockID, err)
+	}
+}
+
+// String describes the election for the logs.
+func (elector *Elector) String() string {
+	return fmt.Sprintf("lock %s in DynamoDB table %s as %s", elector.lockID, elector.table, elector.name)
+}
+
+// leaderRegion is the region of the leader table when --leaderTable is a
+// name: the first of --regions, unless that is all or auto.
+func leaderRegion(regions []string) string {
+	if len(regions) > 0 && regions[0] != "all" && regions[0] != "auto" {
+		return regions[0]
+	}
+	return "us-east-1"
+}
@@ -0,0 +1,65 @@
+package awsnameserver
+
+import (
+	"strings"
+
+	"github.com/foreflight/aws-name-server/pkg/dnsserver"
+)
+
+// addressList is a flag that may be repeated, or given a comma separated
+// list as YAML config files do, e.g. --listenAddress 10.0.0.5:53
+// --listenAddress [fd00::5]:53. The first value given replaces the default.
+type addressList struct {
+	addresses []string
+	set       bool
+}
+
+func (list *addressList) String() string {
+	return strings.Join(list.addresses, ",")
+}
+
+func (list *addressList) Set(value string) error {
+	if !list.set {
+		list.addresses, list.set = nil, true
+	}
+	for _, address := range strings.Split(value, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			list.addresses = append(list.addresses, address)
+		}
+	}
+	return nil
+}
+
+// dnsListeners are the addresses to serve DNS on: UDP on each of
+// udpAddresses and TCP on each of tcpAddresses, or listenAddresses for
+// either that is empty, unless serveUDP or serveTCP is false.
+func dnsListeners(listenAddresses, udpAddresses, tcpAddresses []string, serveUDP, serveTCP bool) []dnsserver.Listener {
+	if len(udpAddresses) == 0 {
+		udpAddresses = listenAddresses
+	}
+	if len(tcpAddresses) == 0 {
+		tcpAddresses = listenAddresses
+	}
+
+	var listeners []dnsserver.Listener
+	if serveUDP {
+		for _, address := range udpAddresses {
+			listeners = append(listeners, dnsserver.Listener{Address: address, Net: "udp"})
+		}
+	}
+	if serveTCP {
+		for _, address := range tcpAddresses {
+			listeners = append(listeners, dnsserver.Listener{Address: address, Net: "tcp"})
+		}
+	}
+	return listeners
+}
+
+// describeListeners lists listeners for the logs, e.g. ":53/udp, :53/tcp".
+func describeListeners(listeners []dnsserver.Listener) string {
+	var described []string
+	for _, listener := range listeners {
+		described = append(described, listener.Address+"/"+listener.Net)
+	}
+	return strings.Join(described, ", ")
+}
@@ -1,23 +1,38 @@
-package main
+package awsnameserver
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
+	"strings"
 	"time"
 
-	"encoding/json"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/miekg/dns"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+	"github.com/foreflight/aws-name-server/pkg/dnsserver"
+	"github.com/foreflight/aws-name-server/pkg/providers"
 )
 
-const USAGE = `Usage: aws-name-server --domain <domain>
+const USAGE = `Usage: aws-name-server [serve] --domain <domain>
                      [ --hostname <hostname>
                        --aws-region us-east-1
                        --aws-access-key-id <access-key>
                        --aws-secret-access-key <secret-key> ]
+       aws-name-server dump --domain <domain>
+       aws-name-server check --domain <domain>
+       aws-name-server query --domain <domain> [ --server <address> ] <name> [<type>]
+       aws-name-server version
+
+serve answers DNS queries, and is the default. dump prints every record as
+JSON, check validates the config and credentials, and query looks a name up,
+all from a fresh discovery of every account, or for query from a running
+instance with --server.
 
 aws-name-server --domain internal.example.com will serve DNS requests for:
 
@@ -28,26 +43,124 @@ aws-name-server --domain internal.example.com will serve DNS requests for:
 
 For more details see https://github.com/danieljimenez/aws-name-server`
 
-const CAPABILITIES = `FATAL
-
-You need to give this program permission to bind to port 53.
-
-Using capabilities (recommended):
- $ sudo setcap cap_net_bind_service=+ep "$(which aws-name-server)"
+// VERSION is the release, set with
+// -ldflags "-X github.com/foreflight/aws-name-server.VERSION=v1.2.3".
+var VERSION = "dev"
 
-Just run it as root (not recommended):
- $ sudo aws-name-server
-`
+// COMMANDS are the subcommands, see USAGE.
+var COMMANDS = []string{"serve", "dump", "check", "query", "version"}
 
-func main() {
+// Main runs the aws-name-server command, see USAGE. It's called by
+// cmd/aws-name-server, the rest of the package is also usable as a library.
+func Main() {
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	known := false
+	for _, name := range COMMANDS {
+		known = known || name == command
+	}
+	if !known {
+		fmt.Println(USAGE)
+		log.Fatalf("unknown command: %s", command)
+	}
+	if command == "version" {
+		fmt.Printf("aws-name-server %s\n", VERSION)
+		return
+	}
 
 	domain := flag.String("domain", "", "the domain hierarchy to serve (e.g. aws.example.com)")
 	hostname := flag.String("hostname", "", "the public hostname of this server (e.g. ec2-12-34-56-78.compute-1.amazonaws.com)")
-	listenAddress := flag.String("listenAddress", ":53", "the public hostname of this server (e.g. ec2-12-34-56-78.compute-1.amazonaws.com)")
-	configFile := flag.String("configFile", "/etc/aws-name-server.conf", "path to a JSON file with an array of AWSAccount structs.")
+	nameServers := flag.String("nameServers", "", "comma separated list of the hostnames in the domain's NS records (e.g. ns1.aws.example.com,ns2.aws.example.com), --hostname alone if empty")
+	listenAddress := &addressList{addresses: []string{":53"}}
+	flag.Var(listenAddress, "listenAddress", "the address to serve DNS on, over UDP and TCP, repeated or comma separated for several, disabled if empty")
+	serveUDP := flag.Bool("udp", true, "serve DNS over UDP")
+	serveTCP := flag.Bool("tcp", true, "serve DNS over TCP")
+	udpAddress := &addressList{}
+	flag.Var(udpAddress, "udpAddress", "the addresses to serve DNS over UDP on, if not --listenAddress")
+	tcpAddress := &addressList{}
+	flag.Var(tcpAddress, "tcpAddress", "the addresses to serve DNS over TCP on, if not --listenAddress")
+	responseCache := flag.Duration("responseCache", 0, "how long to answer repeated queries with the same packed response, disabled if 0 (e.g. 1s)")
+	udpWorkers := flag.Int("udpWorkers", 1, "how many UDP sockets to serve each UDP address on with SO_REUSEPORT, for the kernel to spread queries across")
+	dohAddress := flag.String("dohAddress", "", "address to serve DNS-over-HTTPS on (e.g. :443), disabled if empty")
+	dohCert := flag.String("dohCert", "", "path to the TLS certificate for DNS-over-HTTPS")
+	dohKey := flag.String("dohKey", "", "path to the TLS private key for DNS-over-HTTPS")
+	dnssecKSK := flag.String("dnssecKSK", "", "path prefix of the DNSSEC key-signing key files (e.g. Kaws.example.com.+013+12345), signing is disabled if empty")
+	dnssecZSK := flag.String("dnssecZSK", "", "path prefix of the DNSSEC zone-signing key files, defaults to using the KSK")
+	allowCIDR := flag.String("allowCIDR", "", "comma separated list of subnets allowed to query (e.g. 10.0.0.0/8,192.168.0.0/16), in addition to AllowCIDRs in the config file")
+	gossipAddress := flag.String("gossipAddress", "", "address to gossip records with other servers on (e.g. 0.0.0.0:7946), disabled if empty")
+	gossipJoin := flag.String("gossipJoin", "", "comma separated list of --gossipAddress peers to join (e.g. 10.0.1.5,10.0.2.5:7946)")
+	leaderTable := flag.String("leaderTable", "", "name or ARN of a DynamoDB table to elect a leader in, which alone polls AWS while the rest serve the records it gossips, disabled if empty; requires --gossipAddress")
+	replicate := flag.Bool("replicate", false, "discover nothing from AWS, serving every account with the records --gossipJoin peers discovered")
+	primary := flag.String("primary", "", "address of a primary aws-name-server (e.g. 10.0.0.5:53) to copy the zone from by zone transfers instead of discovering from AWS, disabled if empty")
+	primaryTSIGKey := flag.String("primaryTSIGKey", "", "name of the key in TSIGKeys to sign zone transfers from --primary with, unsigned if empty")
+	notify := flag.String("notify", "", "comma separated list of secondaries (e.g. 10.0.1.5,10.0.2.5:53) to send a NOTIFY whenever the zone changes, disabled if empty")
+	forward := flag.String("forward", "", "comma separated list of upstream resolvers (e.g. 169.254.169.253,8.8.8.8:53) for names outside --domain, which are refused if empty")
+	flattenCNAMEs := flag.Bool("flattenCNAMEs", false, "resolve RDS endpoints and answer with their A records instead of a CNAME")
+	nameTag := flag.String("nameTag", "Name", "the instance tag to serve as <name>.<domain> (e.g. Hostname or aws:autoscaling:groupName)")
+	punycode := flag.Bool("punycode", false, "encode tags with non-ASCII letters as punycode (xn--) labels instead of replacing the letters with -")
+	roleTag := flag.String("roleTag", "Role", "the instance tag to serve as <role>.role.<domain>")
+	route53Zone := flag.String("route53Zone", "", "id of a Route 53 hosted zone to push the records into (e.g. Z0123456789ABCDEFGHIJ), disabled if empty")
+	eventQueue := flag.String("eventQueue", "", "URL of an SQS queue receiving EventBridge events, which trigger early refreshes, disabled if empty")
+	consulAddress := flag.String("consulAddress", "", "address of a Consul agent to register the instances with as services (e.g. 127.0.0.1:8500), disabled if empty")
+	etcdEndpoints := flag.String("etcdEndpoints", "", "comma separated list of etcd endpoints to write the records to in the SkyDNS/CoreDNS format (e.g. http://127.0.0.1:2379), disabled if empty")
+	etcdPrefix := flag.String("etcdPrefix", "/skydns", "the etcd key prefix to write the records under, as the CoreDNS etcd plugin's path")
+	otlpEndpoint := flag.String("otlpEndpoint", "", "URL of an OTLP/HTTP collector to export query and refresh traces to (e.g. http://localhost:4318), disabled if empty")
+	adminAddress := flag.String("adminAddress", "", "address to serve the admin API on (e.g. 127.0.0.1:8053), disabled if empty; requires AdminTokens in --configFile")
+	debugAddress := flag.String("debugAddress", "", "localhost address to serve pprof and expvar on (e.g. 127.0.0.1:6060), disabled if empty")
+	metricsAddress := flag.String("metricsAddress", "", "address to serve Prometheus metrics on (e.g. :9153), disabled if empty")
+	servePublic := flag.Bool("servePublic", false, "answer with instances' public IPs instead of private ones, unless the client matches a View")
+	regions := flag.String("regions", "us-east-1", "comma separated list of regions to discover the current account in, all for every standard region or auto for those enabled in the account")
+	ec2Endpoint := flag.String("ec2Endpoint", "", "URL of the EC2 API (e.g. http://localhost:4566 for LocalStack), the default for the region if empty")
+	rdsEndpoint := flag.String("rdsEndpoint", "", "URL of the RDS API, the default for the region if empty")
+	stsEndpoint := flag.String("stsEndpoint", "", "URL of the STS API roles are assumed with, the default for the region if empty")
+	profile := flag.String("profile", "", "named profile from the shared AWS config files to use for the current account, the default credential chain if empty")
+	refreshInterval := flag.Duration("refreshInterval", cache.REFRESH_INTERVAL, "how often to refresh each account from the AWS APIs")
+	ttl := flag.Duration("ttl", cache.TTL, "the TTL of records just after a refresh")
+	minTTL := flag.Duration("minTTL", cache.MIN_TTL, "the lowest TTL records are served with")
+	statusChecks := flag.Bool("statusChecks", false, "mark instances failing their EC2 status checks unhealthy, answering as --unhealthyAnswer says")
+	targetHealth := flag.Bool("targetHealth", false, "mark instances a load balancer target group reports unhealthy or draining unhealthy, answering as --unhealthyAnswer says")
+	unhealthyAnswer := flag.String("unhealthyAnswer", string(dnsserver.EXCLUDE_UNHEALTHY), "how to answer with unhealthy records while a name has healthy ones: exclude, or deprioritize to answer with them last")
+	includeStopped := flag.Bool("includeStopped", false, "also discover stopped instances, answering for names whose instances are all stopped as --stoppedAnswer says")
+	importZone := flag.String("importZone", "", "a zone file whose records to serve alongside the discovered ones, e.g. one kept by hand for BIND")
+	excludeTag := flag.String("excludeTag", providers.EXCLUDE_TAG, "the instance tag that keeps an instance out of DNS when true")
+	stoppedAnswer := flag.String("stoppedAnswer", "nodata", "how to answer for names whose instances are all stopped: nodata, or txt to add a TXT record giving their state")
+	stoppedTTL := flag.Duration("stoppedTTL", dnsserver.STOPPED_TTL, "the negative TTL of names whose instances are all stopped")
+	negativeTTL := flag.Duration("negativeTTL", dnsserver.NEGATIVE_TTL, "how long resolvers may cache that a name doesn't exist, the SOA's MINIMUM")
+	refreshConcurrency := flag.Int("refreshConcurrency", 8, "how many accounts and regions to refresh at once")
+	services := flag.String("services", "ec2,rds", "comma separated list of AWS services to discover: "+strings.Join(cache.Services(), ", "))
+	statusInterval := flag.Duration("statusInterval", 10*time.Minute, "how often to log a summary of the accounts' refreshes, warning of each that is stale, disabled if 0")
+	queryLogSample := flag.Float64("queryLogSample", 1, "fraction of queries to log, 1 for all and 0 for none (refusals and errors are always logged)")
+	queryLogInterval := flag.Duration("queryLogInterval", 0, "how often to log the busiest names and clients, disabled if 0")
+	queryLogTopN := flag.Int("queryLogTopN", 10, "how many names and clients each --queryLogInterval summary lists")
+	dnstapSocket := flag.String("dnstapSocket", "", "path of a Unix socket to send dnstap frames for every query and response to, disabled if empty")
+	serialFile := flag.String("serialFile", "", "path to keep the SOA serial in, so it never goes backwards across restarts, disabled if empty")
+	cacheFile := flag.String("cacheFile", "", "path to save the records to after each refresh, and restore them from at startup, disabled if empty")
+	hostsFile := flag.String("hostsFile", "", "path to keep up to date in /etc/hosts format with every name and its private IP, disabled if empty")
+	configFile := flag.String("configFile", "/etc/aws-name-server.conf", "path to a JSON file with an array of AWSAccount structs, or a YAML file (ending in .yaml or .yml) of any settings, which flags override")
+	watchConfig := flag.Bool("watchConfig", false, "reload the accounts whenever --configFile changes, as well as on SIGHUP")
+	dryRun := flag.Bool("dryRun", false, "the same as the check command")
+	format := flag.String("format", "json", "for the dump command, what to print: "+strings.Join(DUMP_FORMATS, " or "))
+	queryServer := flag.String("server", "", "for the query command, the address of a running instance to ask (e.g. 127.0.0.1:53), discovering afresh if empty")
 	help := flag.Bool("help", false, "show help")
 
-	flag.Parse()
+	flag.CommandLine.Parse(args)
+	if *dryRun {
+		command = "check"
+	}
+	if command == "dump" && *format != "json" && *format != "zonefile" && *format != "hosts" {
+		fmt.Println(USAGE)
+		log.Fatalf("unknown --format: %s", *format)
+	}
+	if command == "query" && flag.NArg() == 0 {
+		fmt.Println(USAGE)
+		log.Fatalf("missing name to query")
+	}
+	config := getConfig(configFile)
+	if err := config.applyFlags(); err != nil {
+		log.Fatalf("FATAL: %s: %s", *configFile, err)
+	}
 
 	if *domain == "" {
 		fmt.Println(USAGE)
@@ -57,50 +170,297 @@ func main() {
 		os.Exit(0)
 	}
 
-	hostnameFuture := getHostname()
-	accounts := getConfig(configFile)
+	if *refreshInterval <= 0 || *minTTL > *ttl {
+		log.Fatalf("FATAL: --refreshInterval must be positive and --minTTL no more than --ttl")
+	}
+	cache.REFRESH_INTERVAL, cache.TTL, cache.MIN_TTL = *refreshInterval, *ttl, *minTTL
+	cache.EC2_ENDPOINT, cache.RDS_ENDPOINT, cache.STS_ENDPOINT = *ec2Endpoint, *rdsEndpoint, *stsEndpoint
+	if *stoppedAnswer != "nodata" && *stoppedAnswer != "txt" {
+		log.Fatalf("FATAL: --stoppedAnswer must be nodata or txt, not %q", *stoppedAnswer)
+	}
+	providers.INCLUDE_STOPPED = *includeStopped
+	providers.EXCLUDE_TAG = *excludeTag
+	if *unhealthyAnswer != string(dnsserver.EXCLUDE_UNHEALTHY) && *unhealthyAnswer != string(dnsserver.DEPRIORITIZE_UNHEALTHY) {
+		log.Fatalf("FATAL: --unhealthyAnswer must be exclude or deprioritize, not %q", *unhealthyAnswer)
+	}
+	providers.STATUS_CHECKS = *statusChecks
+	providers.TARGET_HEALTH = *targetHealth
 
-	caches, recordCount, err := NewCaches(accounts, *domain)
-	if err != nil {
+	if *otlpEndpoint != "" {
+		if err := setupTracing(context.Background(), *otlpEndpoint); err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+	}
+
+	hostnameFuture := getHostname()
+	if err := cache.SetServices(strings.Split(*services, ",")); err != nil {
+		log.Fatalf("FATAL: %s", err)
+	}
+	cache.PUNYCODE = *punycode
+	cache.SetTagKey(cache.LOOKUP_NAME, *nameTag)
+	cache.SetTagKey(cache.LOOKUP_ROLE, *roleTag)
+	if err := cache.AddTagLookups(config.LookupTags); err != nil {
 		log.Fatalf("FATAL: %s", err)
 	}
+	cache.INCLUDE_TAGS, cache.EXCLUDE_TAGS = config.IncludeTags, config.ExcludeTags
+
+	mainAccount := &cache.AWSAccount{
+		NickName: "main",
+		Regions:  strings.Split(*regions, ","),
+		Profile:  *profile,
+	}
+	accounts := append(config.Accounts, mainAccount)
+	if *replicate {
+		if *gossipAddress == "" {
+			log.Fatalf("FATAL: --replicate needs --gossipAddress")
+		}
+		for _, account := range accounts {
+			account.Replicated = true
+		}
+	}
+
+	var caches *cache.CacheSet
+	var recordCount int
+	var err error
+	switch command {
+	case "check":
+		if !check(accounts, *domain, *refreshConcurrency) {
+			os.Exit(1)
+		}
+		return
+	case "dump":
+		caches = discoverOrExit(accounts, *domain, *refreshConcurrency)
+		switch *format {
+		case "json":
+			printJSON(dumpCaches(caches.All(), dns.Fqdn(*domain), ""))
+			return
+		case "hosts":
+			writeHosts(os.Stdout, caches.All(), *domain)
+			return
+		}
+	case "query":
+		if *queryServer != "" {
+			if err := queryRemote(*queryServer, flag.Args()); err != nil {
+				log.Fatalf("FATAL: %s", err)
+			}
+			return
+		}
+		caches = discoverOrExit(accounts, *domain, *refreshConcurrency)
+	default:
+		if *primary != "" {
+			// secondaries serve the primary's zone, discovering nothing themselves
+			accounts = nil
+		}
+		var snapshot *cache.Snapshot
+		if *cacheFile != "" {
+			snapshot = cache.LoadSnapshot(*cacheFile)
+		}
+		if caches, recordCount, err = cache.NewCaches(accounts, *domain, *refreshConcurrency, snapshot); err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		if *cacheFile != "" {
+			NewSnapshotWriter(*cacheFile, caches).write()
+		}
+		if *hostsFile != "" {
+			NewHostsWriter(*hostsFile, *domain, caches).write()
+		}
+	}
 
 	if *hostname == "" {
 		*hostname = <-hostnameFuture
 	}
 
-	server := NewNameServer(*domain, *hostname, caches)
-	log.Printf("Serving %d DNS records for *.%s from %s%s", recordCount, server.domain, server.hostname, *listenAddress)
-
-	go checkNSRecordMatches(server.domain, server.hostname)
-	go server.listenAndServe(*listenAddress, "udp")
-	server.listenAndServe(*listenAddress, "tcp")
-}
+	server := dnsserver.NewNameServer(*domain, *hostname, caches)
+	server.QueryLog = &dnsserver.QueryLog{Sample: *queryLogSample, Interval: *queryLogInterval, TopN: *queryLogTopN}
+	if *dnstapSocket != "" {
+		if server.Tap, err = dnsserver.NewTap(*dnstapSocket, *hostname); err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		log.Printf("Sending dnstap frames to %s", *dnstapSocket)
+	}
+	server.TSIGSecrets = dnsserver.TSIGSecrets(config.TSIGKeys)
+	server.RequireTSIG = config.RequireTSIG
+	if server.RequireTSIG && len(server.TSIGSecrets) == 0 {
+		log.Fatalf("FATAL: RequireTSIG is set but no TSIGKeys are configured")
+	}
+	if *primary != "" {
+		server.Secondary = dnsserver.NewSecondary(*primary, *primaryTSIGKey)
+		if _, ok := server.TSIGSecrets[server.Secondary.TSIGKey]; server.Secondary.TSIGKey != "" && !ok {
+			log.Fatalf("FATAL: --primaryTSIGKey %s isn't one of the TSIGKeys", *primaryTSIGKey)
+		}
+	}
+	if err = dnsserver.ParseViews(config.Views); err != nil {
+		log.Fatalf("FATAL: %s", err)
+	}
+	server.Views = config.Views
+	if *servePublic {
+		server.DefaultView = dnsserver.PUBLIC_VIEW
+	}
+	server.NotifyAddresses = dnsserver.ParseUpstreams(strings.Split(*notify, ","))
+	server.NameServers = dnsserver.ParseNameServers(strings.Split(*nameServers, ","))
+	server.Wildcards = config.Wildcards
+	if server.CNAMEs, err = dnsserver.ParseCNAMEs(config.CNAMEs); err != nil {
+		log.Fatalf("FATAL: %s", err)
+	}
+	if server.StaticRecords, err = dnsserver.ParseStaticRecords(config.StaticRecords, *domain); err != nil {
+		log.Fatalf("FATAL: %s", err)
+	}
+	if *importZone != "" {
+		if err = dnsserver.ImportZone(*importZone, *domain, server.StaticRecords); err != nil {
+			log.Fatalf("FATAL: --importZone %s", err)
+		}
+	}
+	server.FlattenCNAMEs = *flattenCNAMEs
+	server.UDPWorkers = *udpWorkers
+	server.NegativeTTL = *negativeTTL
+	if err = dnsserver.ParseSOASettings(&config.SOA); err != nil {
+		log.Fatalf("FATAL: %s", err)
+	}
+	server.SOASettings = config.SOA
+	if config.SOA.Minimum.Duration > 0 && !flagGiven("negativeTTL") {
+		server.NegativeTTL = config.SOA.Minimum.Duration
+	}
+	// NewNameServer built the zone before any of the above was set
+	server.UpdateZone()
+	if *serialFile != "" {
+		NewSerialWriter(*serialFile, server, caches)
+	}
+	server.Unhealthy = dnsserver.UnhealthyAnswer(*unhealthyAnswer)
+	if *includeStopped {
+		server.Stopped = &dnsserver.StoppedAnswer{TTL: *stoppedTTL, TXT: *stoppedAnswer == "txt"}
+	}
+	if *responseCache > 0 {
+		server.ResponseCache = dnsserver.NewResponseCache(*responseCache)
+	}
+	server.VPCs = config.VPCs
+	if server.AllowedSubnets, err = dnsserver.ParseCIDRs(append(config.AllowCIDRs, strings.Split(*allowCIDR, ",")...)); err != nil {
+		log.Fatalf("FATAL: %s", err)
+	}
+	if server.ReverseZones, err = dnsserver.ParseCIDRs(config.ReverseCIDRs); err != nil {
+		log.Fatalf("FATAL: ReverseCIDRs: %s", err)
+	}
+	if *dnssecKSK != "" {
+		if server.Signer, err = dnsserver.NewSigner(*dnssecKSK, *dnssecZSK); err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		log.Printf("Signing %s with DNSSEC, publish this DS record in the parent zone: %s", server.Domain(), server.Signer.DS())
+	}
+	if command == "query" {
+		if err := queryLocal(server, flag.Args()); err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		return
+	}
+	if command == "dump" {
+		if err := server.WriteZoneFile(os.Stdout); err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		return
+	}
+	if *eventQueue != "" {
+		listener, err := NewEventListener(*eventQueue, caches)
+		if err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		log.Printf("Refreshing on events from %s", *eventQueue)
+		go listener.Listen()
+	}
+	if *route53Zone != "" {
+		if server.Route53, err = dnsserver.NewRoute53Sync(*route53Zone, *domain); err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		log.Printf("Pushing records to Route 53 zone %s", *route53Zone)
+		server.PushRoute53()
+	}
+	if *etcdEndpoints != "" {
+		if server.Etcd, err = dnsserver.NewEtcdSync(strings.Split(*etcdEndpoints, ","), *etcdPrefix); err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		log.Printf("Writing records to etcd under %s", *etcdPrefix)
+		server.PushEtcd()
+	}
+	var gossip *Gossip
+	if *gossipAddress != "" {
+		if gossip, err = NewGossip(*gossipAddress, strings.Split(*gossipJoin, ","), config.GossipKey, caches); err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		log.Printf("Gossiping records on %s with %d servers", *gossipAddress, gossip.Members())
+	}
+	var elector *Elector
+	if *leaderTable != "" {
+		if gossip == nil {
+			log.Fatalf("FATAL: --leaderTable needs --gossipAddress")
+		}
+		if elector, err = NewElector(*leaderTable, server.Domain(), leaderRegion(mainAccount.Regions), caches); err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		log.Printf("Electing a leader with %s", elector)
+		go elector.Run()
+	}
+	if *consulAddress != "" {
+		consulSync, err := NewConsulSync(*consulAddress, caches)
+		if err != nil {
+			log.Fatalf("FATAL: %s", err)
+		}
+		log.Printf("Registering instances with Consul at %s", *consulAddress)
+		consulSync.push()
+	}
+	listeners := dnsListeners(listenAddress.addresses, udpAddress.addresses, tcpAddress.addresses, *serveUDP, *serveTCP)
+	log.Printf("Serving %d DNS records for *.%s from %s on %s", recordCount, server.Domain(), server.Hostname(), describeListeners(listeners))
 
-func getConfig(configFile *string) []*AWSAccount {
-	var accounts []*AWSAccount
+	if server.Upstreams = dnsserver.ParseUpstreams(strings.Split(*forward, ",")); len(server.Upstreams) > 0 {
+		log.Printf("Forwarding other queries to %s", strings.Join(server.Upstreams, ", "))
+	}
+	server.Handle()
 
-	configFileObj, err := os.Open(*configFile)
-	if err != nil {
-		log.Printf("WARN: %s", err)
+	if server.Secondary != nil {
+		log.Printf("Transferring %s from %s", server.Domain(), server.Secondary.Primary)
+		go server.FollowPrimary()
 	} else {
-		accounts = []*AWSAccount{
-			{
-				NickName: `json:"NickName"`,
-				Arn:      `json:"ARN"`,
-				Region:   `json:"Region"`,
-			},
+		go reloadOnSIGHUP(*configFile, caches, mainAccount)
+		if *watchConfig {
+			if err := reloadOnChange(*configFile, caches, mainAccount); err != nil {
+				log.Fatalf("FATAL: %s", err)
+			}
+			log.Printf("Reloading accounts whenever %s changes", *configFile)
 		}
 	}
-
-	if configFileObj != nil && err == nil {
-		jsonParser := json.NewDecoder(configFileObj)
-		if err = jsonParser.Decode(&accounts); err != nil {
+	if server.QueryLog.Interval > 0 {
+		go server.QueryLog.Summarize()
+	}
+	if *statusInterval > 0 {
+		go caches.LogStatus(*statusInterval)
+	}
+	go checkNSRecordMatches(server.Domain(), server.Hostname())
+	if *metricsAddress != "" {
+		log.Printf("Serving metrics on %s%s", *metricsAddress, METRICS_PATH)
+		go listenAndServeMetrics(*metricsAddress, caches)
+	}
+	if *debugAddress != "" {
+		if err := listenAndServeDebug(*debugAddress, caches); err != nil {
 			log.Fatalf("FATAL: %s", err)
 		}
+		log.Printf("Serving pprof and expvar on %s/debug/", *debugAddress)
 	}
-
-	return accounts
+	if *adminAddress != "" {
+		if len(config.AdminTokens) == 0 {
+			log.Fatalf("FATAL: --adminAddress needs AdminTokens in %s", *configFile)
+		}
+		log.Printf("Serving the admin API on %s", *adminAddress)
+		go NewAdminAPI(server.Domain(), caches, config.AdminTokens).ListenAndServe(*adminAddress)
+	}
+	if *dohAddress != "" {
+		log.Printf("Serving DNS-over-HTTPS on %s%s", *dohAddress, dnsserver.DOH_PATH)
+		go server.ListenAndServeHTTPS(*dohAddress, *dohCert, *dohKey)
+	}
+	if len(listeners) == 0 {
+		log.Printf("Not serving DNS as --listenAddress is empty")
+	} else {
+		go server.Serve(listeners)
+	}
+	notifyReady(caches)
+	waitForShutdown(server, caches, elector, gossip)
+	log.Printf("Stopped")
 }
 
 func getHostname() chan string {
@@ -108,14 +468,13 @@ func getHostname() chan string {
 	go func() {
 
 		// This can be slow on non-EC2-instances
-		mySession, err := session.NewSession()
-		if err != nil {
-			log.Fatalf("FATAL: %s", err)
-		}
-
-		if hostname, err := ec2metadata.New(mySession).GetMetadata("public-hostname"); err == nil {
-			result <- string(hostname)
-			return
+		if output, err := imds.New(imds.Options{}).GetMetadata(context.Background(), &imds.GetMetadataInput{Path: "public-hostname"}); err == nil {
+			hostname, err := ioutil.ReadAll(output.Content)
+			output.Content.Close()
+			if err == nil {
+				result <- string(hostname)
+				return
+			}
 		}
 
 		if hostname, err := os.Hostname(); err == nil {
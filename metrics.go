@@ -0,0 +1,23 @@
+package awsnameserver
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// METRICS_PATH is where --metricsAddress serves Prometheus metrics.
+const METRICS_PATH = "/metrics"
+
+// listenAndServeMetrics serves Prometheus metrics on address, including
+// the state of each of caches.
+func listenAndServeMetrics(address string, caches *cache.CacheSet) {
+	prometheus.MustRegister(cache.NewCollector(caches))
+
+	mux := http.NewServeMux()
+	mux.Handle(METRICS_PATH, promhttp.Handler())
+	log.Fatalf("%s", http.ListenAndServe(address, mux))
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// MAX_BACKOFF caps how long a failing account waits between refreshes.
+const MAX_BACKOFF = 10 * time.Minute
+
+// nextRefresh works out how long to wait before refreshing the cache again
+// after a refresh that returned err. Consecutive failures back off
+// exponentially from the refresh interval, with full jitter, and never
+// retry sooner than a Retry-After header asks.
+func (cache *Cache) nextRefresh(err error) time.Duration {
+	interval := cache.RefreshInterval()
+	if err == nil {
+		cache.failures = 0
+		return interval
+	}
+
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary {
+		throttles := atomic.AddUint64(&cache.throttles, 1)
+		log.Printf("WARN: %s account in %s was throttled (%d times so far)", cache.awsAccount.NickName, cache.awsAccount.Region, throttles)
+	}
+
+	cache.failures++
+	backoff := MAX_BACKOFF
+	if cache.failures < 16 && interval<<cache.failures < MAX_BACKOFF {
+		backoff = interval << cache.failures
+	}
+	if backoff < interval {
+		backoff = interval
+	}
+	delay := interval + time.Duration(rand.Int63n(int64(backoff-interval)+1))
+	if after := retryAfter(err); after > delay {
+		delay = after
+	}
+	log.Printf("WARN: %s account in %s failed %d times in a row, retrying in %s", cache.awsAccount.NickName, cache.awsAccount.Region, cache.failures, delay.Round(time.Second))
+	return delay
+}
+
+// Throttles is the number of refreshes that AWS has throttled.
+func (cache *Cache) Throttles() uint64 {
+	return atomic.LoadUint64(&cache.throttles)
+}
+
+// retryAfter is the delay asked for by a Retry-After header in the HTTP
+// response behind err, if there is one.
+func retryAfter(err error) time.Duration {
+	var responseError *awshttp.ResponseError
+	if !errors.As(err, &responseError) || responseError.HTTPResponse() == nil {
+		return 0
+	}
+	header := responseError.HTTPResponse().Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := time.Parse(time.RFC1123, header); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,732 @@
+// Package cache discovers EC2 instances and other AWS resources in one or
+// more accounts and regions, and keeps their records up-to-date for serving
+// as DNS names under a domain. The resources of each AWS service come from a
+// Provider, see the providers package.
+package cache
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The length of time to cache the results of ec2-describe-instances.
+// This value is exposed as the TTL of the DNS record (down to a minimum
+// of MIN_TTL). Both are set by --ttl and --minTTL.
+var TTL = 1 * time.Minute
+var MIN_TTL = 10 * time.Second
+
+// REFRESH_INTERVAL is how often each cache is refreshed, set by --refreshInterval.
+var REFRESH_INTERVAL = 15 * time.Second
+
+// LookupTag represents the type of tag we're caching by.
+type LookupTag uint8
+
+const (
+	// LOOKUP_NAME for when tag:Name=<value>
+	LOOKUP_NAME LookupTag = iota
+	// LOOKUP_ROLE for when tag:Role=<value>
+	LOOKUP_ROLE
+	// LOOKUP_WILDCARD for when tag:Name=*.<value>
+	LOOKUP_WILDCARD
+	// LOOKUP_READER for Aurora reader endpoints, <cluster>.ro.<domain>
+	LOOKUP_READER
+	// LOOKUP_LB for load balancers, <name>.lb.<domain>
+	LOOKUP_LB
+	// LOOKUP_CACHE for ElastiCache primary endpoints, <id>.cache.<domain>
+	LOOKUP_CACHE
+	// LOOKUP_CACHE_READER for ElastiCache reader endpoints, <id>.ro.cache.<domain>
+	LOOKUP_CACHE_READER
+	// LOOKUP_ECS for ECS services, <service>.ecs.<domain>
+	LOOKUP_ECS
+	// LOOKUP_EKS for EKS node groups and nodes, <nodegroup>.eks.<domain>
+	LOOKUP_EKS
+	// LOOKUP_EFS for EFS mount targets, <name>.efs.<domain>
+	LOOKUP_EFS
+	// LOOKUP_MSK for MSK clusters and brokers, <cluster>.msk.<domain>
+	LOOKUP_MSK
+	// LOOKUP_EIP for Elastic IPs, <name>.eip.<domain>
+	LOOKUP_EIP
+	// LOOKUP_NAT for NAT gateways, <name>.nat.<domain>
+	LOOKUP_NAT
+	// LOOKUP_VPCE for interface VPC endpoints, <service>.vpce.<domain>
+	LOOKUP_VPCE
+	// LOOKUP_ASG for auto scaling groups, <asg-name>.asg.<domain>
+	LOOKUP_ASG
+	// LOOKUP_CUSTOM and above are for the LookupTags in the config file
+	LOOKUP_CUSTOM
+)
+
+// Key is used to cache results in O(1) lookup structures: the subdomain a
+// name is served under and the name itself, e.g. {LOOKUP_ROLE, "web"} for
+// web.role.<domain>.
+type Key struct {
+	LookupTag
+	Value string
+}
+
+// Record represents the DNS record for one EC2 instance, or another AWS
+// resource served by name.
+type Record struct {
+	Name       string
+	InstanceID string
+	CName      string
+	PublicName string
+	PublicIP   net.IP
+	PrivateIP  net.IP
+	ValidUntil time.Time
+	// MinTTL is the lowest TTL served once ValidUntil is near or past.
+	MinTTL time.Duration
+	// TTLOverride is the dns:ttl tag, zero if the instance isn't tagged,
+	// which replaces the account's TTL for the record, see stamp.
+	TTLOverride time.Duration
+
+	AvailabilityZone string
+	VpcID            string
+	// ZoneLocal records are only served to clients in their own availability
+	// zone, if there are any there.
+	ZoneLocal bool
+	// Port is the default SRV port, from the Port tag.
+	Port uint16
+	// Services maps SRV service names to ports, from dns:srv:<service> tags.
+	Services map[string]uint16
+	// Weight is the dns:weight tag, nil if the instance isn't tagged.
+	Weight *uint16
+	// Index is the dns:index tag, nil if the instance isn't tagged, which
+	// fixes its number for nth lookups in place of its launch order.
+	Index *uint16
+	// HealthCheckID is the Route 53 health check in the dns:healthcheck tag.
+	HealthCheckID string
+	// Unhealthy records are failing a health check, such as EC2's status
+	// checks, and are answered after or without the healthy ones, see
+	// dnsserver.UnhealthyAnswer.
+	Unhealthy bool
+	// Stopped instances are kept apart from the records served, and only
+	// looked up with LookupStopped.
+	Stopped bool
+	// LaunchTime is when the instance was launched, zero if unknown, which
+	// orders nth lookups, see Before.
+	LaunchTime time.Time
+}
+
+// Subnet is a VPC subnet, used to work out which availability zone a client is in.
+type Subnet struct {
+	*net.IPNet
+	AvailabilityZone string
+}
+
+// AWSAccount is an account to discover, from the config file, or the one
+// whose credentials the server runs with.
+type AWSAccount struct {
+	NickName string
+	Arn      string
+	Region   string
+	// Regions discovers the account in several regions, one cache each,
+	// e.g. ["us-east-1", "eu-west-1"] or ["all"]. It overrides Region.
+	Regions []string
+
+	// Profile reads credentials from a named profile in the shared config
+	// files (~/.aws/config and ~/.aws/credentials), including SSO profiles.
+	Profile string
+
+	// ExternalID, PolicyArns and SessionName are passed to AssumeRole.
+	ExternalID  string
+	PolicyArns  []string
+	SessionName string
+
+	// RefreshInterval, TTL and MinTTL override --refreshInterval, --ttl
+	// and --minTTL for the account, e.g. "1m".
+	RefreshInterval Duration
+	TTL             Duration
+	MinTTL          Duration
+
+	// EC2Endpoint, RDSEndpoint and STSEndpoint override --ec2Endpoint,
+	// --rdsEndpoint and --stsEndpoint for the account, e.g.
+	// "https://ec2-fips.us-gov-west-1.amazonaws.com".
+	EC2Endpoint string
+	RDSEndpoint string
+	STSEndpoint string
+
+	// Services overrides --services for the account, e.g. ["ec2"] for an
+	// account the role has no RDS permissions in.
+	Services []string
+
+	// IncludeTags and ExcludeTags override the config file's for the
+	// account, e.g. {"Environment": "prod"}, see INCLUDE_TAGS.
+	IncludeTags map[string]string
+	ExcludeTags map[string]string
+
+	// Replicated accounts aren't discovered by this server, which serves
+	// the records peers discovered instead, see CacheSet.Replicate. They
+	// need Regions listed, as "auto" asks AWS.
+	Replicated bool
+}
+
+// DEFAULT_SESSION_NAME is the RoleSessionName for accounts without SessionName.
+const DEFAULT_SESSION_NAME = "aws-name-server"
+
+// REFRESH_TIMEOUT bounds the AWS calls made by each refresh.
+const REFRESH_TIMEOUT = time.Minute
+
+// CREDENTIAL_EXPIRY_WINDOW is how long before they expire assumed role
+// credentials are renewed.
+const CREDENTIAL_EXPIRY_WINDOW = 5 * time.Minute
+
+// Cache maintains a local cache of the records for one account and region.
+// It refreshes every REFRESH_INTERVAL.
+type Cache struct {
+	awsAccount AWSAccount
+	records    map[Key][]*Record
+	stopped    map[Key][]*Record
+	reverse    map[string][]*Record
+	subnets    []Subnet
+	mutex      sync.RWMutex
+	domain     string
+	listeners  []func()
+
+	// created and refreshed are when the cache was created and last
+	// refreshed successfully, stale whether the last refresh failed.
+	created   time.Time
+	refreshed time.Time
+	stale     bool
+	// attempted is when the last refresh finished, successfully or not,
+	// and duration how long it took. lastError is why the last one that
+	// failed did, at lastErrorTime.
+	attempted     time.Time
+	duration      time.Duration
+	lastError     string
+	lastErrorTime time.Time
+
+	// wake triggers an early refresh, see Wake, and stop ends the
+	// refreshes of a cache that is no longer served, see Stop.
+	wake chan struct{}
+	stop chan struct{}
+
+	// terminating holds instances that are about to terminate out of the
+	// cache until the time given, see Terminating.
+	terminating map[string]time.Time
+
+	// failures counts consecutive failed refreshes, for backing off, and
+	// throttles every throttled one.
+	failures  uint
+	throttles uint64
+
+	// collisions are the names different tag values sanitized to in the
+	// last refresh, see NoteSanitized.
+	collisions []Collision
+
+	// fetched holds the records each provider last fetched successfully,
+	// which are served in place of a fetch that fails.
+	fetched map[string]map[Key][]*Record
+
+	// awsConfig is reused across refreshes so assumed role credentials are
+	// only renewed as they near expiry.
+	awsConfig *aws.Config
+	// clients create the AWS API clients, see Clients.
+	clients *Clients
+}
+
+// NewCache creates an empty Cache for account in region, which creates its
+// AWS API clients with clients, at the account's endpoints. It isn't
+// refreshed until it is scheduled, or part of a CacheSet.
+func NewCache(account *AWSAccount, region string, domain string, clients *Clients) *Cache {
+	awsAccount := *account
+	awsAccount.Region = region
+	return &Cache{
+		awsAccount: awsAccount,
+		records:    make(map[Key][]*Record),
+		domain:     domain,
+		created:    time.Now(),
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		clients:    clients.forAccount(&awsAccount),
+	}
+}
+
+// config loads the AWS config for the account in its Region, assuming its
+// role with clients' STS if it has an ARN. Calls are retried adaptively
+// when throttled.
+func (account *AWSAccount) config(ctx context.Context, clients *Clients) (aws.Config, error) {
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(account.Region),
+		config.WithSharedConfigProfile(account.Profile),
+		config.WithRetryMode(aws.RetryModeAdaptive),
+	)
+	if err != nil {
+		return cfg, err
+	}
+	// trace each AWS call, including assuming the role, as a child of the refresh
+	otelaws.AppendMiddlewares(&cfg.APIOptions)
+
+	// if the account has an ARN, that means it's a child account, so we'll need to use role switching.
+	// The cache keeps the credentials until they are about to expire.
+	if account.Arn != "" {
+		provider := stscreds.NewAssumeRoleProvider(clients.STS(cfg), account.Arn, func(options *stscreds.AssumeRoleOptions) {
+			options.Duration = time.Hour
+			options.RoleSessionName = DEFAULT_SESSION_NAME
+			if account.SessionName != "" {
+				options.RoleSessionName = account.SessionName
+			}
+			if account.ExternalID != "" {
+				options.ExternalID = aws.String(account.ExternalID)
+			}
+			for _, arn := range account.PolicyArns {
+				options.PolicyARNs = append(options.PolicyARNs, ststypes.PolicyDescriptorType{Arn: aws.String(arn)})
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider, func(options *aws.CredentialsCacheOptions) {
+			options.ExpiryWindow = CREDENTIAL_EXPIRY_WINDOW
+		})
+	}
+
+	return cfg, nil
+}
+
+// DEFAULT_REGION is where regions are enumerated for accounts with "all" or
+// "auto" Regions and no Region.
+const DEFAULT_REGION = "us-east-1"
+
+// regions lists the regions to discover the account in. "all" expands to
+// every region that doesn't need opting in to and "auto" to the regions
+// enabled in the account, including opted-in ones, from ec2.DescribeRegions.
+// Accounts without Regions fall back to Region.
+func (account *AWSAccount) regions(ctx context.Context, clients *Clients) ([]string, error) {
+	if len(account.Regions) == 0 {
+		return []string{account.Region}, nil
+	}
+	var regions []string
+	for _, region := range account.Regions {
+		switch region {
+		case "all":
+			standard, err := account.describeRegions(ctx, clients, "opt-in-not-required")
+			if err != nil {
+				return nil, err
+			}
+			regions = append(regions, standard...)
+		case "auto":
+			enabled, err := account.describeRegions(ctx, clients, "opt-in-not-required", "opted-in")
+			if err != nil {
+				return nil, err
+			}
+			regions = append(regions, enabled...)
+		default:
+			regions = append(regions, region)
+		}
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
+
+// describeRegions asks EC2 which regions the account has with the given
+// opt-in statuses.
+func (account AWSAccount) describeRegions(ctx context.Context, clients *Clients, statuses ...string) ([]string, error) {
+	if account.Region == "" {
+		account.Region = DEFAULT_REGION
+	}
+	cfg, err := account.config(ctx, clients)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := clients.EC2(cfg).DescribeRegions(ctx, &ec2.DescribeRegionsInput{
+		Filters: []ec2types.Filter{{
+			Name:   aws.String("opt-in-status"),
+			Values: statuses,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []string
+	for _, region := range result.Regions {
+		regions = append(regions, aws.ToString(region.RegionName))
+	}
+	log.Printf("Found %d %s regions for %s account", len(regions), strings.Join(statuses, "/"), account.NickName)
+	return regions, nil
+}
+
+// setRecords updates the cache with a new set of Records, leaving out
+// terminating instances and keeping stopped ones apart.
+func (cache *Cache) setRecords(records map[Key][]*Record) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.storeRecords(records)
+}
+
+// storeRecords is setRecords for callers already holding cache.mutex.
+func (cache *Cache) storeRecords(records map[Key][]*Record) {
+	records, stopped := splitStopped(cache.withoutTerminating(records))
+	reverse := make(map[string][]*Record)
+	seen := make(map[*Record]bool)
+	for _, list := range records {
+		for _, record := range list {
+			if record.PrivateIP == nil || seen[record] {
+				continue
+			}
+			seen[record] = true
+			ip := record.PrivateIP.String()
+			reverse[ip] = append(reverse[ip], record)
+		}
+	}
+
+	cache.records = records
+	cache.stopped = stopped
+	cache.reverse = reverse
+}
+
+// allRecords is the cache's records together with its stopped ones, as
+// setRecords takes them, for paths that replace the records with a changed
+// copy, or save them. cache.mutex must be held.
+func (cache *Cache) allRecords() map[Key][]*Record {
+	if len(cache.stopped) == 0 {
+		return cache.records
+	}
+	all := make(map[Key][]*Record, len(cache.records)+len(cache.stopped))
+	for key, list := range cache.records {
+		all[key] = list
+	}
+	for key, list := range cache.stopped {
+		// copied, as the running records' slice may be being served
+		all[key] = append(append([]*Record(nil), all[key]...), list...)
+	}
+	return all
+}
+
+// splitStopped separates the Stopped records from the rest.
+func splitStopped(records map[Key][]*Record) (running map[Key][]*Record, stopped map[Key][]*Record) {
+	running = make(map[Key][]*Record, len(records))
+	stopped = make(map[Key][]*Record)
+	for key, list := range records {
+		if !anyStopped(list) {
+			running[key] = list
+			continue
+		}
+		for _, record := range list {
+			if record.Stopped {
+				stopped[key] = append(stopped[key], record)
+			} else {
+				running[key] = append(running[key], record)
+			}
+		}
+	}
+	return running, stopped
+}
+
+func anyStopped(records []*Record) bool {
+	for _, record := range records {
+		if record.Stopped {
+			return true
+		}
+	}
+	return false
+}
+
+// EKS_NODEGROUP_TAG is set by EKS on the instances in a managed node group.
+const EKS_NODEGROUP_TAG = "eks:nodegroup-name"
+
+// allow _ in DNS name
+var SANE_DNS_NAME = regexp.MustCompile("^[\\w-]+$")
+var SANE_DNS_REPL = regexp.MustCompile("[^\\w-]+")
+
+// Sanitize lower cases tag and replaces anything not allowed in a DNS
+// label with "-", unless PUNYCODE encodes it.
+func Sanitize(tag string) string {
+	out := strings.ToLower(tag)
+	if SANE_DNS_NAME.MatchString(out) {
+		return out
+	}
+	if PUNYCODE {
+		if encoded, ok := punycode(out); ok {
+			return encoded
+		}
+	}
+	return SANE_DNS_REPL.ReplaceAllString(out, "-")
+}
+
+// refresh fetches the records of every enabled provider, and replaces the
+// cache's records with them. A provider that fails is served with the
+// records it last fetched, so one service the account's role can't describe
+// doesn't hold back the rest, and the refresh only fails if every one does.
+func (cache *Cache) refresh() (err error) {
+	if cache.awsAccount.Arn == "" {
+		log.Printf("Refreshing data for %s account in %s.", cache.awsAccount.NickName, cache.awsAccount.Region)
+	} else {
+		log.Printf("Refreshing data for %s account in %s via %s", cache.awsAccount.NickName, cache.awsAccount.Region, cache.awsAccount.Arn)
+	}
+	records := make(map[Key][]*Record)
+
+	ctx, cancel := context.WithTimeout(context.Background(), REFRESH_TIMEOUT)
+	defer cancel()
+	ctx, span := startRefreshSpan(ctx, cache)
+	defer func() { endRefreshSpan(span, err) }()
+	ctx, noted := withSanitized(ctx)
+
+	if cache.awsConfig == nil {
+		cfg, err := cache.awsAccount.config(ctx, cache.clients)
+		if err != nil {
+			return err
+		}
+		cache.awsConfig = &cfg
+	}
+	cfg := *cache.awsConfig
+
+	// do the fetches for all caches, in the order the providers were registered
+	var fetchErr error
+	succeeded := 0
+	fetched := make(map[string]map[Key][]*Record)
+	for _, provider := range enabledProviders(cache.awsAccount.Services) {
+		found, err := provider.Fetch(ctx, cfg, cache.clients, records)
+		if err != nil {
+			log.Printf("ERROR: %s account in %s: fetching %s: %s", cache.awsAccount.NickName, cache.awsAccount.Region, provider.Service, err)
+			providerErrors.WithLabelValues(cache.awsAccount.NickName, cache.awsAccount.Region, provider.Service).Inc()
+			fetchErr = err
+			found = cache.fetched[provider.Service]
+		} else {
+			log.Printf("Fetched %d names from %s for %s account in %s", len(found), provider.Service, cache.awsAccount.NickName, cache.awsAccount.Region)
+			cache.stamp(found)
+			succeeded++
+		}
+		fetched[provider.Service] = found
+		for k, v := range found {
+			if provider.Merge {
+				records[k] = append(records[k], v...)
+			} else {
+				records[k] = v
+			}
+		}
+	}
+
+	if fetchErr != nil && succeeded == 0 {
+		return fetchErr
+	}
+	cache.fetched = fetched
+
+	// subnets are only used to prefer nearby instances, so carry on without them
+	subnetsResult, err := cache.clients.EC2(cfg).DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{})
+	if err != nil {
+		log.Printf("WARN: %s account in %s: can't describe subnets: %s", cache.awsAccount.NickName, cache.awsAccount.Region, err)
+	} else {
+		cache.setSubnets(createSubnets(subnetsResult))
+	}
+
+	// update the cache records
+	cache.setCollisions(noted.collisions(cache))
+	cache.setRecords(cache.withoutPublicLabel(records))
+	cache.markFresh()
+	cache.notify()
+	return nil
+}
+
+// stamp applies the account's TTLs to the records just fetched, which may
+// differ from the defaults providers use, or their own TTLOverride, which
+// also lowers their floor if it is below the account's. Records carried
+// over from an earlier refresh keep counting down from theirs.
+func (cache *Cache) stamp(found map[Key][]*Record) {
+	now := time.Now()
+	validUntil := now.Add(cache.ttl())
+	for _, list := range found {
+		for _, record := range list {
+			record.ValidUntil = validUntil
+			record.MinTTL = cache.minTTL()
+			if record.TTLOverride > 0 {
+				record.ValidUntil = now.Add(record.TTLOverride)
+				if record.TTLOverride < record.MinTTL {
+					record.MinTTL = record.TTLOverride
+				}
+			}
+		}
+	}
+}
+
+// Subscribe registers fn to be called after every successful refresh.
+func (cache *Cache) Subscribe(fn func()) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.listeners = append(cache.listeners, fn)
+}
+
+// notify calls the listeners registered with Subscribe.
+func (cache *Cache) notify() {
+	cache.mutex.RLock()
+	listeners := cache.listeners
+	cache.mutex.RUnlock()
+
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+func createSubnets(subnetsResult *ec2.DescribeSubnetsOutput) []Subnet {
+	var subnets []Subnet
+	for _, subnet := range subnetsResult.Subnets {
+		if subnet.CidrBlock == nil || subnet.AvailabilityZone == nil {
+			continue
+		}
+		if _, cidr, err := net.ParseCIDR(*subnet.CidrBlock); err == nil {
+			subnets = append(subnets, Subnet{cidr, *subnet.AvailabilityZone})
+		}
+	}
+	return subnets
+}
+
+// setSubnets updates the cache with the account's subnets
+func (cache *Cache) setSubnets(subnets []Subnet) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.subnets = subnets
+}
+
+// ZoneOf returns the availability zone of the subnet containing ip, or "" if
+// it isn't in any of this account's subnets.
+func (cache *Cache) ZoneOf(ip net.IP) string {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	for _, subnet := range cache.subnets {
+		if subnet.Contains(ip) {
+			return subnet.AvailabilityZone
+		}
+	}
+	return ""
+}
+
+// SubnetOf returns the subnet of this account's containing ip, or nil if
+// there isn't one.
+func (cache *Cache) SubnetOf(ip net.IP) *net.IPNet {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	for _, subnet := range cache.subnets {
+		if subnet.Contains(ip) {
+			return subnet.IPNet
+		}
+	}
+	return nil
+}
+
+// Nickname is the DNS label for the cache's account, e.g. web.<nickname>.<domain>.
+func (cache *Cache) Nickname() string {
+	return Sanitize(cache.awsAccount.NickName)
+}
+
+// Account is the account and region the cache discovers.
+func (cache *Cache) Account() AWSAccount {
+	return cache.awsAccount
+}
+
+// Lookup a node in the Cache either by Name or Role.
+func (cache *Cache) Lookup(tag LookupTag, value string) []*Record {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.records[Key{tag, value}]
+}
+
+// LookupStopped finds the stopped instances by Name, Role or another tag,
+// which are only discovered with --includeStopped.
+func (cache *Cache) LookupStopped(tag LookupTag, value string) []*Record {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.stopped[Key{tag, value}]
+}
+
+// Records returns every Record in the cache, by Key. The map must not be modified.
+func (cache *Cache) Records() map[Key][]*Record {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.records
+}
+
+// ReverseLookup finds the Records whose private IP is ip.
+func (cache *Cache) ReverseLookup(ip net.IP) []*Record {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.reverse[ip.String()]
+}
+
+// Size is the number of names in the cache.
+func (cache *Cache) Size() int {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return len(cache.records)
+}
+
+// Before orders records for nth lookups such as 1.web.<domain>, by launch
+// time then instance id, so an instance keeps its index across refreshes
+// and servers until one launched before it goes away. Records that are not
+// instances fall back to their address.
+func (record *Record) Before(other *Record) bool {
+	if !record.LaunchTime.Equal(other.LaunchTime) {
+		return record.LaunchTime.Before(other.LaunchTime)
+	}
+	if record.InstanceID != other.InstanceID {
+		return record.InstanceID < other.InstanceID
+	}
+	if record.CName != other.CName {
+		return record.CName < other.CName
+	}
+	return record.PrivateIP.String() < other.PrivateIP.String()
+}
+
+// ServicePort returns the port to advertise in SRV records for service,
+// falling back to the Port tag. It returns 0 if no port is known.
+func (record *Record) ServicePort(service string) uint16 {
+	if port, ok := record.Services[service]; ok {
+		return port
+	}
+	return record.Port
+}
+
+// TTL is the TTL to serve the record with at now, counting down to
+// ValidUntil but no lower than MinTTL.
+func (record *Record) TTL(now time.Time) time.Duration {
+	floor := record.MinTTL
+	if floor == 0 {
+		floor = MIN_TTL
+	}
+	if ttl := record.ValidUntil.Sub(now); ttl > floor {
+		return ttl
+	}
+	return floor
+}
+
+// RefreshInterval is how often the cache is refreshed.
+func (cache *Cache) RefreshInterval() time.Duration {
+	if cache.awsAccount.RefreshInterval.Duration > 0 {
+		return cache.awsAccount.RefreshInterval.Duration
+	}
+	return REFRESH_INTERVAL
+}
+
+// ttl is how long the cache's records are valid for after a refresh.
+func (cache *Cache) ttl() time.Duration {
+	if cache.awsAccount.TTL.Duration > 0 {
+		return cache.awsAccount.TTL.Duration
+	}
+	return TTL
+}
+
+// minTTL is the lowest TTL the cache's records are served with.
+func (cache *Cache) minTTL() time.Duration {
+	if cache.awsAccount.MinTTL.Duration > 0 {
+		return cache.awsAccount.MinTTL.Duration
+	}
+	return MIN_TTL
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// fakeEC2 describes the account's subnets, in place of EC2. The test
+// providers don't call it for anything else.
+type fakeEC2 struct {
+	EC2API
+	subnets []ec2types.Subnet
+}
+
+func (client fakeEC2) DescribeSubnets(ctx context.Context, params *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	return &ec2.DescribeSubnetsOutput{Subnets: client.subnets}, nil
+}
+
+var fakeClients = &Clients{
+	EC2: func(cfg aws.Config) EC2API {
+		return fakeEC2{subnets: []ec2types.Subnet{{CidrBlock: aws.String("10.0.0.0/24"), AvailabilityZone: aws.String("us-east-1a")}}}
+	},
+}
+
+// record is a running instance at ip.
+func record(id string, ip string) *Record {
+	return &Record{Name: id, InstanceID: id, PrivateIP: net.ParseIP(ip)}
+}
+
+// fixed returns a Fetch finding records, new ones each refresh as a
+// provider's would be.
+func fixed(records func() map[Key][]*Record) Fetch {
+	return func(ctx context.Context, cfg aws.Config, clients *Clients, found map[Key][]*Record) (map[Key][]*Record, error) {
+		return records(), nil
+	}
+}
+
+func init() {
+	Register(&Provider{Service: "test-hosts", Fetch: fixed(func() map[Key][]*Record {
+		stopped := record("i-stopped", "10.0.0.9")
+		stopped.Stopped = true
+		return map[Key][]*Record{
+			{LOOKUP_NAME, "web"}: {record("i-web", "10.0.0.1"), stopped},
+			{LOOKUP_NAME, "db"}:  {record("i-db", "10.0.0.2")},
+		}
+	})})
+	Register(&Provider{Service: "test-discovery", Merge: true, Fetch: fixed(func() map[Key][]*Record {
+		return map[Key][]*Record{{LOOKUP_NAME, "web"}: {record("web-task", "10.0.0.3")}}
+	})})
+	Register(&Provider{Service: "test-database", Fetch: fixed(func() map[Key][]*Record {
+		return map[Key][]*Record{{LOOKUP_NAME, "db"}: {{Name: "db", CName: "db.example.com."}}}
+	})})
+}
+
+// ips are the sorted private IPs of records.
+func ips(records []*Record) []string {
+	var addresses []string
+	for _, record := range records {
+		addresses = append(addresses, record.PrivateIP.String())
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+func expect(t *testing.T, name string, got []string, want []string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s: got %v, want %v", name, got, want)
+	}
+}
+
+func TestRefreshMergesProviders(t *testing.T) {
+	account := &AWSAccount{NickName: "main", Services: []string{"test-hosts", "test-discovery", "test-database"}}
+	cache := NewCache(account, "us-east-1", "internal.", fakeClients)
+	if err := NewScheduler(1).RefreshAll([]*Cache{cache}); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Refreshed().IsZero() {
+		t.Fatal("the refresh wasn't recorded")
+	}
+
+	expect(t, "merged", ips(cache.Lookup(LOOKUP_NAME, "web")), []string{"10.0.0.1", "10.0.0.3"})
+	expect(t, "stopped", ips(cache.LookupStopped(LOOKUP_NAME, "web")), []string{"10.0.0.9"})
+	if db := cache.Lookup(LOOKUP_NAME, "db"); len(db) != 1 || db[0].CName != "db.example.com." {
+		t.Errorf("db: got %v, want only the later provider's CNAME", db)
+	}
+	expect(t, "reverse", ips(cache.ReverseLookup(net.ParseIP("10.0.0.3"))), []string{"10.0.0.3"})
+	if zone := cache.ZoneOf(net.ParseIP("10.0.0.1")); zone != "us-east-1a" {
+		t.Errorf("zone: got %q, want us-east-1a", zone)
+	}
+}
+
+func TestBefore(t *testing.T) {
+	launched := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	early, late, other := record("i-b", "10.0.0.4"), record("i-c", "10.0.0.5"), record("i-a", "10.0.0.6")
+	early.LaunchTime, late.LaunchTime, other.LaunchTime = launched, launched.Add(time.Hour), launched.Add(time.Hour)
+	records := []*Record{
+		late,
+		{PrivateIP: net.ParseIP("10.0.0.2")},
+		early,
+		other,
+		{PrivateIP: net.ParseIP("10.0.0.1")},
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Before(records[j]) })
+
+	var order []string
+	for _, record := range records {
+		order = append(order, record.PrivateIP.String())
+	}
+	// addresses without a launch time first, then by launch time and instance id
+	expect(t, "order", order, []string{"10.0.0.1", "10.0.0.2", "10.0.0.4", "10.0.0.6", "10.0.0.5"})
+}
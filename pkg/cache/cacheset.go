@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// CacheSet holds the caches being served, one per account and region. The
+// caches change when the config is reloaded, so users call All each time
+// rather than keeping the slice.
+type CacheSet struct {
+	domain    string
+	clients   *Clients
+	scheduler *Scheduler
+
+	mutex     sync.RWMutex
+	caches    []*Cache
+	listeners []func()
+
+	// reloading serializes Reload, which may be called on SIGHUP and
+	// when the config file changes at once.
+	reloading sync.Mutex
+}
+
+// NewCaches creates a CacheSet that uses the provided accounts to lookup
+// instances, one Cache per account and region. It refreshes them all, up to
+// concurrency at a time, and schedules them to keep up-to-date. Accounts
+// that fail their first refresh don't stop the others being served, and
+// serve their records from snapshot if there is one.
+func NewCaches(accounts []*AWSAccount, domain string, concurrency int, snapshot *Snapshot) (*CacheSet, int, error) {
+	caches, err := newCaches(accounts, domain, DEFAULT_CLIENTS)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if snapshot != nil {
+		snapshot.restore(caches)
+	}
+
+	// accounts that can't be refreshed yet are served stale (or empty) and retried
+	scheduler := NewScheduler(concurrency)
+	if err := scheduler.RefreshAll(caches); err != nil {
+		log.Printf("ERROR: %s", err)
+	}
+	scheduler.Schedule(caches)
+
+	var recordCount = 0
+	for _, cache := range caches {
+		recordCount = recordCount + cache.Size()
+	}
+	return &CacheSet{domain: domain, clients: DEFAULT_CLIENTS, scheduler: scheduler, caches: caches}, recordCount, nil
+}
+
+// newCaches creates an empty Cache for each region of each of accounts,
+// using clients.
+func newCaches(accounts []*AWSAccount, domain string, clients *Clients) ([]*Cache, error) {
+	var caches = []*Cache{}
+	for _, awsAccount := range accounts {
+		if _, err := chooseServices(awsAccount.Services); err != nil {
+			return nil, fmt.Errorf("%s account: %s", awsAccount.NickName, err)
+		}
+		regions, err := awsAccount.regions(context.Background(), clients.forAccount(awsAccount))
+		if err != nil {
+			return nil, err
+		}
+		for _, region := range regions {
+			caches = append(caches, NewCache(awsAccount, region, domain, clients))
+		}
+	}
+	return caches, nil
+}
+
+// All returns the caches currently being served. The slice must not be modified.
+func (set *CacheSet) All() []*Cache {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	return set.caches
+}
+
+// Subscribe registers fn to be called after every successful refresh of
+// any cache, and whenever caches are added or removed.
+func (set *CacheSet) Subscribe(fn func()) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	set.listeners = append(set.listeners, fn)
+	for _, cache := range set.caches {
+		cache.Subscribe(fn)
+	}
+}
+
+// Reload changes the caches to serve accounts. Caches whose account and
+// region are unchanged carry on as they are. New ones are refreshed before
+// being served, and changed ones keep serving their previous records until
+// that refresh replaces them. Caches for accounts and regions that are gone
+// stop being served and refreshed.
+func (set *CacheSet) Reload(accounts []*AWSAccount) error {
+	set.reloading.Lock()
+	defer set.reloading.Unlock()
+
+	candidates, err := newCaches(accounts, set.domain, set.clients)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]*Cache)
+	for _, cache := range set.All() {
+		existing[cache.awsAccount.NickName+"/"+cache.awsAccount.Region] = cache
+	}
+	var caches, added []*Cache
+	changedNames := make(map[string]bool)
+	changed := 0
+	for _, cache := range candidates {
+		name := cache.awsAccount.NickName + "/" + cache.awsAccount.Region
+		old, ok := existing[name]
+		if ok && reflect.DeepEqual(old.awsAccount, cache.awsAccount) {
+			caches = append(caches, old)
+			delete(existing, name)
+			continue
+		}
+		if ok {
+			log.Printf("Changed %s account in %s", cache.awsAccount.NickName, cache.awsAccount.Region)
+			cache.seed(old)
+			changedNames[name] = true
+			changed++
+		} else {
+			log.Printf("Added %s account in %s", cache.awsAccount.NickName, cache.awsAccount.Region)
+		}
+		caches = append(caches, cache)
+		added = append(added, cache)
+	}
+
+	if err := set.scheduler.RefreshAll(added); err != nil {
+		log.Printf("ERROR: %s", err)
+	}
+
+	set.mutex.Lock()
+	set.caches = caches
+	listeners := set.listeners
+	for _, cache := range added {
+		for _, fn := range listeners {
+			cache.Subscribe(fn)
+		}
+	}
+	set.mutex.Unlock()
+
+	for name, cache := range existing {
+		if !changedNames[name] {
+			log.Printf("Removed %s account in %s", cache.awsAccount.NickName, cache.awsAccount.Region)
+		}
+		cache.Stop()
+	}
+	set.scheduler.Schedule(added)
+	for _, fn := range listeners {
+		fn()
+	}
+
+	log.Printf("Reloaded accounts: serving %d accounts and regions, %d added, %d changed, %d removed", len(caches), len(added)-changed, changed, len(existing)-changed)
+	return nil
+}
+
+// Follow stops the scheduled refreshes while following is set, as another
+// server is the leader whose records are replicated, see Replicate. Once
+// it is cleared, the caches are refreshed straight away.
+func (set *CacheSet) Follow(following bool) {
+	if set.scheduler.following.Swap(following) == following || following {
+		return
+	}
+	for _, cache := range set.All() {
+		cache.Wake()
+	}
+}
+
+// seed starts a new cache off with the records of the cache it replaces.
+func (cache *Cache) seed(old *Cache) {
+	old.mutex.RLock()
+	defer old.mutex.RUnlock()
+
+	cache.records, cache.stopped, cache.reverse, cache.subnets = old.records, old.stopped, old.reverse, old.subnets
+	cache.fetched = old.fetched
+	cache.refreshed = old.refreshed
+}
+
+// Stop ends the refreshes of every cache, for shutting down.
+func (set *CacheSet) Stop() {
+	for _, cache := range set.All() {
+		cache.Stop()
+	}
+}
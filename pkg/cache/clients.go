@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// EC2API is the part of the EC2 API used to discover instances, regions,
+// subnets and the like, as implemented by *ec2.Client.
+type EC2API interface {
+	DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+	DescribeInstanceStatus(ctx context.Context, params *ec2.DescribeInstanceStatusInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceStatusOutput, error)
+	DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error)
+	DescribeSubnets(ctx context.Context, params *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error)
+	DescribeAddresses(ctx context.Context, params *ec2.DescribeAddressesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeAddressesOutput, error)
+	DescribeNatGateways(ctx context.Context, params *ec2.DescribeNatGatewaysInput, optFns ...func(*ec2.Options)) (*ec2.DescribeNatGatewaysOutput, error)
+	DescribeVpcEndpoints(ctx context.Context, params *ec2.DescribeVpcEndpointsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeVpcEndpointsOutput, error)
+	DescribeNetworkInterfaces(ctx context.Context, params *ec2.DescribeNetworkInterfacesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeNetworkInterfacesOutput, error)
+}
+
+// RDSAPI is the part of the RDS API used to discover databases, as
+// implemented by *rds.Client.
+type RDSAPI interface {
+	DescribeDBInstances(ctx context.Context, params *rds.DescribeDBInstancesInput, optFns ...func(*rds.Options)) (*rds.DescribeDBInstancesOutput, error)
+	DescribeDBClusters(ctx context.Context, params *rds.DescribeDBClustersInput, optFns ...func(*rds.Options)) (*rds.DescribeDBClustersOutput, error)
+}
+
+// STSAPI is the part of the STS API used to assume accounts' roles, as
+// implemented by *sts.Client.
+type STSAPI interface {
+	AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+}
+
+// Clients create the AWS API clients a Cache uses from its account's
+// config, and are passed on to each provider's Fetch. NewCache can be given
+// Clients returning other implementations of the interfaces, such as
+// fakes, so refreshes can run without AWS.
+type Clients struct {
+	EC2 func(cfg aws.Config) EC2API
+	RDS func(cfg aws.Config) RDSAPI
+	STS func(cfg aws.Config) STSAPI
+}
+
+// DEFAULT_CLIENTS create the AWS SDK's clients, and are used by NewCaches
+// and Discover.
+var DEFAULT_CLIENTS = &Clients{
+	EC2: func(cfg aws.Config) EC2API { return ec2.NewFromConfig(cfg) },
+	RDS: func(cfg aws.Config) RDSAPI { return rds.NewFromConfig(cfg) },
+	STS: func(cfg aws.Config) STSAPI { return sts.NewFromConfig(cfg) },
+}
+
+// EC2_ENDPOINT, RDS_ENDPOINT and STS_ENDPOINT are the URLs of the AWS APIs,
+// for LocalStack, GovCloud, China or FIPS endpoints the SDK doesn't choose
+// by itself. The SDK's default for the region is used if they are empty.
+var (
+	EC2_ENDPOINT string
+	RDS_ENDPOINT string
+	STS_ENDPOINT string
+)
+
+// forAccount wraps clients to create them at account's endpoints, or the
+// default ones if the account doesn't override them, and to filter its
+// instances by their tags.
+func (clients *Clients) forAccount(account *AWSAccount) *Clients {
+	ec2Endpoint := firstNonEmpty(account.EC2Endpoint, EC2_ENDPOINT)
+	rdsEndpoint := firstNonEmpty(account.RDSEndpoint, RDS_ENDPOINT)
+	stsEndpoint := firstNonEmpty(account.STSEndpoint, STS_ENDPOINT)
+	include, exclude := INCLUDE_TAGS, EXCLUDE_TAGS
+	if account.IncludeTags != nil {
+		include = account.IncludeTags
+	}
+	if account.ExcludeTags != nil {
+		exclude = account.ExcludeTags
+	}
+	return &Clients{
+		EC2: func(cfg aws.Config) EC2API {
+			return withTagFilters(clients.EC2(withEndpoint(cfg, ec2Endpoint)), include, exclude)
+		},
+		RDS: func(cfg aws.Config) RDSAPI { return clients.RDS(withEndpoint(cfg, rdsEndpoint)) },
+		STS: func(cfg aws.Config) STSAPI { return clients.STS(withEndpoint(cfg, stsEndpoint)) },
+	}
+}
+
+// withEndpoint is cfg with its endpoint set to url, unless url is empty.
+func withEndpoint(cfg aws.Config, url string) aws.Config {
+	if url != "" {
+		cfg.BaseEndpoint = aws.String(url)
+	}
+	return cfg
+}
+
+// firstNonEmpty returns the first of values that isn't "".
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
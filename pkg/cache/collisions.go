@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Collision is a name that several different tag values sanitize to, such
+// as "my db" and "my-db", whose instances are served together.
+type Collision struct {
+	Account string
+	Region  string
+	// Name is the name served, e.g. my-db.aws.example.com.
+	Name string
+	// Values maps each tag value to the instances tagged with it.
+	Values map[string][]string
+}
+
+// sanitized notes the tag values behind each Key during a refresh, see
+// NoteSanitized.
+type sanitized struct {
+	mutex  sync.Mutex
+	values map[Key]map[string][]string
+}
+
+type sanitizedKey struct{}
+
+// withSanitized returns ctx carrying a sanitized for the providers to
+// note their tag values in.
+func withSanitized(ctx context.Context) (context.Context, *sanitized) {
+	noted := &sanitized{values: make(map[Key]map[string][]string)}
+	return context.WithValue(ctx, sanitizedKey{}, noted), noted
+}
+
+// NoteSanitized records that the resource id was served as key because of
+// the tag value raw, so values that Sanitize to the same key are reported
+// as collisions. Values differing only in case aren't, as names are matched
+// case-insensitively anyway.
+func NoteSanitized(ctx context.Context, key Key, raw string, id string) {
+	noted, ok := ctx.Value(sanitizedKey{}).(*sanitized)
+	if !ok {
+		return
+	}
+	noted.mutex.Lock()
+	defer noted.mutex.Unlock()
+
+	if noted.values[key] == nil {
+		noted.values[key] = make(map[string][]string)
+	}
+	noted.values[key][raw] = append(noted.values[key][raw], id)
+}
+
+// collisions lists the keys noted with different values, sorted by name.
+func (noted *sanitized) collisions(cache *Cache) []Collision {
+	noted.mutex.Lock()
+	defer noted.mutex.Unlock()
+
+	var collisions []Collision
+	for key, values := range noted.values {
+		distinct := make(map[string]bool)
+		for raw := range values {
+			distinct[strings.ToLower(raw)] = true
+		}
+		if len(distinct) < 2 {
+			continue
+		}
+		collisions = append(collisions, Collision{
+			Account: cache.awsAccount.NickName,
+			Region:  cache.awsAccount.Region,
+			Name:    KeyName(key, cache.domain),
+			Values:  values,
+		})
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i].Name < collisions[j].Name })
+	return collisions
+}
+
+// setCollisions replaces the cache's collisions, logging them if they have
+// changed, so they aren't repeated every refresh.
+func (cache *Cache) setCollisions(collisions []Collision) {
+	cache.mutex.Lock()
+	changed := !reflect.DeepEqual(cache.collisions, collisions)
+	cache.collisions = collisions
+	cache.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+	for _, collision := range collisions {
+		var values []string
+		for raw, ids := range collision.Values {
+			values = append(values, raw+" ("+strings.Join(ids, ", ")+")")
+		}
+		sort.Strings(values)
+		log.Printf("WARN: %s account in %s: different tags all serve %s: %s", cache.awsAccount.NickName, cache.awsAccount.Region, collision.Name, strings.Join(values, "; "))
+	}
+}
+
+// Collisions lists the names that several different tag values sanitized
+// to in the cache's last refresh.
+func (cache *Cache) Collisions() []Collision {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.collisions
+}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Discover runs one discovery pass of every account and region, up to
+// concurrency at a time, assuming each role first, for the commands that
+// don't serve. The caches aren't kept up-to-date. errs has why each cache
+// failed, or nil if it didn't.
+func Discover(accounts []*AWSAccount, domain string, concurrency int) (caches *CacheSet, errs []error, err error) {
+	list, err := newCaches(accounts, domain, DEFAULT_CLIENTS)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scheduler := NewScheduler(concurrency)
+	errs = make([]error, len(list))
+	var wg sync.WaitGroup
+	for i, cache := range list {
+		wg.Add(1)
+		go func(i int, cache *Cache) {
+			defer wg.Done()
+			if errs[i] = cache.assumeRole(); errs[i] == nil {
+				errs[i] = scheduler.refresh(cache)
+			}
+		}(i, cache)
+	}
+	wg.Wait()
+	return &CacheSet{domain: domain, clients: DEFAULT_CLIENTS, scheduler: scheduler, caches: list}, errs, nil
+}
+
+// assumeRole loads the cache's AWS config and fetches its credentials,
+// assuming the account's role if it has one, so a bad role is reported
+// as such rather than by whichever call first needs it.
+func (cache *Cache) assumeRole() error {
+	ctx, cancel := context.WithTimeout(context.Background(), REFRESH_TIMEOUT)
+	defer cancel()
+
+	cfg, err := cache.awsAccount.config(ctx, cache.clients)
+	if err != nil {
+		return err
+	}
+	if _, err := cfg.Credentials.Retrieve(ctx); err != nil {
+		return fmt.Errorf("credentials: %s", err)
+	}
+	cache.awsConfig = &cfg
+	return nil
+}
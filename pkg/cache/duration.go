@@ -0,0 +1,25 @@
+package cache
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration is a time.Duration written in the config file as a string such
+// as "30s" or "5m".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
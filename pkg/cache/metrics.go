@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	refreshDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aws_name_server_refresh_duration_seconds",
+		Help:    "Time taken to refresh each account and region from the AWS APIs.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 2, 10),
+	}, []string{"account", "region"})
+	refreshErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aws_name_server_refresh_errors_total",
+		Help: "Failed refreshes of each account and region.",
+	}, []string{"account", "region"})
+	providerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aws_name_server_provider_errors_total",
+		Help: "Failed fetches of each service, which don't fail the rest of the refresh.",
+	}, []string{"account", "region", "service"})
+	assumeRoleFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aws_name_server_assume_role_failures_total",
+		Help: "Refreshes that failed because the account's role couldn't be assumed.",
+	}, []string{"account"})
+)
+
+func init() {
+	prometheus.MustRegister(refreshDuration, refreshErrors, providerErrors, assumeRoleFailures)
+}
+
+// observeRefresh records the metrics for a refresh of cache that took since
+// start and returned err.
+func observeRefresh(cache *Cache, start time.Time, err error) {
+	account, region := cache.awsAccount.NickName, cache.awsAccount.Region
+	refreshDuration.WithLabelValues(account, region).Observe(time.Since(start).Seconds())
+	if err == nil {
+		return
+	}
+	refreshErrors.WithLabelValues(account, region).Inc()
+	if isAssumeRoleFailure(err) {
+		assumeRoleFailures.WithLabelValues(account).Inc()
+	}
+}
+
+// isAssumeRoleFailure returns whether err came from sts:AssumeRole, which
+// is wrapped inside the error of whichever call needed the credentials.
+func isAssumeRoleFailure(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if operation, ok := err.(*smithy.OperationError); ok && operation.Service() == "STS" && operation.Operation() == "AssumeRole" {
+			return true
+		}
+	}
+	return false
+}
+
+// Collector reports the size and health of each of a CacheSet's caches
+// when scraped.
+type Collector struct {
+	caches *CacheSet
+}
+
+// NewCollector creates a Collector for caches, for registering with
+// Prometheus.
+func NewCollector(caches *CacheSet) *Collector {
+	return &Collector{caches: caches}
+}
+
+var (
+	cacheRecordsDesc = prometheus.NewDesc("aws_name_server_cache_records",
+		"Names served for each account and region.", []string{"account", "region"}, nil)
+	cacheStalenessDesc = prometheus.NewDesc("aws_name_server_cache_staleness_seconds",
+		"How long each account and region has been served stale, 0 when fresh.", []string{"account", "region"}, nil)
+	cacheThrottlesDesc = prometheus.NewDesc("aws_name_server_throttles_total",
+		"Refreshes of each account and region that AWS throttled.", []string{"account", "region"}, nil)
+)
+
+func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheRecordsDesc
+	ch <- cacheStalenessDesc
+	ch <- cacheThrottlesDesc
+}
+
+func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, cache := range collector.caches.All() {
+		account, region := cache.awsAccount.NickName, cache.awsAccount.Region
+		ch <- prometheus.MustNewConstMetric(cacheRecordsDesc, prometheus.GaugeValue, float64(cache.Size()), account, region)
+		ch <- prometheus.MustNewConstMetric(cacheStalenessDesc, prometheus.GaugeValue, cache.Staleness().Seconds(), account, region)
+		ch <- prometheus.MustNewConstMetric(cacheThrottlesDesc, prometheus.CounterValue, float64(cache.Throttles()), account, region)
+	}
+}
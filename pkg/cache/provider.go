@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Fetch discovers the records of an AWS service with cfg, making the EC2 and
+// RDS clients with clients, which may be replaced, see Clients. found holds
+// the records of the providers fetched before it in the same refresh, e.g.
+// so auto scaling groups can refer to the instances already found.
+type Fetch func(ctx context.Context, cfg aws.Config, clients *Clients, found map[Key][]*Record) (map[Key][]*Record, error)
+
+// Provider discovers one AWS service, such as ec2 or elb. Providers are
+// fetched in the order they were registered, and the records of later ones
+// replace those of earlier ones with the same Key, unless Merge is set.
+type Provider struct {
+	// Service is the provider's name in --services.
+	Service string
+	Fetch   Fetch
+	// Merge appends the records to any of the same Key, for services that
+	// share a namespace with instances, such as Cloud Map.
+	Merge bool
+}
+
+var (
+	providersMutex sync.RWMutex
+	providers      []*Provider
+	// enabled are the services discovered in each account.
+	enabled = map[string]bool{"ec2": true, "rds": true}
+)
+
+// Register adds a provider, usually from the init function of the package
+// that implements it, see the providers package.
+func Register(provider *Provider) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+
+	providers = append(providers, provider)
+}
+
+// Services lists the registered providers' services, in the order they are
+// fetched.
+func Services() []string {
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+
+	var services []string
+	for _, provider := range providers {
+		services = append(services, provider.Service)
+	}
+	return services
+}
+
+// SetServices chooses which AWS services to discover, e.g. ["ec2", "elb"].
+func SetServices(services []string) error {
+	chosen, err := chooseServices(services)
+	if err != nil {
+		return err
+	}
+
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+	enabled = chosen
+	return nil
+}
+
+// chooseServices checks services are all registered, returning them as a set.
+func chooseServices(services []string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	for _, service := range Services() {
+		known[service] = true
+	}
+
+	chosen := make(map[string]bool)
+	for _, service := range services {
+		service = strings.TrimSpace(service)
+		if !known[service] {
+			return nil, fmt.Errorf("unknown service %q, expected one of %s", service, strings.Join(Services(), ", "))
+		}
+		chosen[service] = true
+	}
+	return chosen, nil
+}
+
+// enabledProviders are the providers of services, or of the services chosen
+// by SetServices if it is empty. services must have been checked with
+// chooseServices.
+func enabledProviders(services []string) []*Provider {
+	chosen, _ := chooseServices(services)
+
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+
+	if len(services) == 0 {
+		chosen = enabled
+	}
+	var list []*Provider
+	for _, provider := range providers {
+		if chosen[provider.Service] {
+			list = append(list, provider)
+		}
+	}
+	return list
+}
@@ -0,0 +1,31 @@
+package cache
+
+import (
+	"regexp"
+
+	"golang.org/x/net/idna"
+)
+
+// PUNYCODE makes Sanitize encode tags with non-ASCII letters as punycode
+// labels, e.g. "café" as "xn--caf-dma", instead of replacing the letters
+// with "-", so internationalized names stay distinct and can be decoded.
+var PUNYCODE bool
+
+// UNSAFE_ASCII matches the ASCII that isn't allowed in a DNS label, leaving
+// the rest of Unicode for punycode to encode.
+var UNSAFE_ASCII = regexp.MustCompile("[\\x00-\\x2c\\x2e-\\x2f\\x3a-\\x40\\x5b-\\x5e\\x60\\x7b-\\x7f]+")
+
+// IDNA maps and encodes labels as for lookups, but allows "_" as the rest
+// of Sanitize does.
+var IDNA = idna.New(idna.MapForLookup(), idna.StrictDomainName(false))
+
+// punycode encodes tag, already lower cased, as a single punycode label. It
+// returns false if tag is ASCII, or can't be encoded in a valid label.
+func punycode(tag string) (string, bool) {
+	label := UNSAFE_ASCII.ReplaceAllString(tag, "-")
+	encoded, err := IDNA.ToASCII(label)
+	if err != nil || encoded == label || len(encoded) > 63 {
+		return "", false
+	}
+	return encoded, true
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Scheduler refreshes caches on a shared pool of workers, so no more than
+// concurrency refreshes run at once however many accounts and regions there
+// are.
+type Scheduler struct {
+	slots chan struct{}
+	// following skips the scheduled refreshes while another server is
+	// the leader, see CacheSet.Follow.
+	following atomic.Bool
+}
+
+// NewScheduler creates a Scheduler running up to concurrency refreshes at once.
+func NewScheduler(concurrency int) *Scheduler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Scheduler{slots: make(chan struct{}, concurrency)}
+}
+
+// refresh refreshes cache once a worker is free, leaving its previous
+// records being served as stale if that fails.
+func (scheduler *Scheduler) refresh(cache *Cache) error {
+	scheduler.slots <- struct{}{}
+	defer func() { <-scheduler.slots }()
+
+	start := time.Now()
+	err := cache.refresh()
+	observeRefresh(cache, start, err)
+	cache.recordAttempt(time.Since(start), err)
+	if err != nil {
+		cache.markStale()
+	}
+	return err
+}
+
+// RefreshAll refreshes every cache concurrently, returning the first error.
+func (scheduler *Scheduler) RefreshAll(caches []*Cache) error {
+	errs := make([]error, len(caches))
+	var wg sync.WaitGroup
+	for i, cache := range caches {
+		if cache.awsAccount.Replicated {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, cache *Cache) {
+			defer wg.Done()
+			errs[i] = scheduler.refresh(cache)
+		}(i, cache)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Schedule keeps caches up-to-date in the background. Their first refreshes
+// are staggered across the refresh interval so they don't all hit the AWS
+// APIs at the same moment, and accounts whose refreshes fail back off.
+func (scheduler *Scheduler) Schedule(caches []*Cache) {
+	for i, cache := range caches {
+		if cache.awsAccount.Replicated {
+			log.Printf("Replicating %s account in %s from peers", cache.awsAccount.NickName, cache.awsAccount.Region)
+			continue
+		}
+		interval := cache.RefreshInterval()
+		offset := interval * time.Duration(i) / time.Duration(len(caches))
+		log.Printf("Scheduling goroutine for %s account in %s every %s", cache.awsAccount.NickName, cache.awsAccount.Region, interval)
+		go func(cache *Cache) {
+			if !cache.wait(offset + interval) {
+				return
+			}
+			for {
+				var err error
+				if !scheduler.following.Load() {
+					if err = scheduler.refresh(cache); err != nil {
+						log.Println("ERROR: " + err.Error())
+					}
+				}
+				if !cache.wait(cache.nextRefresh(err)) {
+					log.Printf("Stopped refreshing %s account in %s", cache.awsAccount.NickName, cache.awsAccount.Region)
+					return
+				}
+			}
+		}(cache)
+	}
+}
+
+// Wake asks for the cache to be refreshed as soon as possible, instead of
+// waiting for its next scheduled refresh. Requests made while one is
+// pending are coalesced.
+func (cache *Cache) Wake() {
+	select {
+	case cache.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop ends the cache's refreshes, once it is no longer served.
+func (cache *Cache) Stop() {
+	close(cache.stop)
+}
+
+// wait sleeps for d, or until the cache is woken. It returns false if the
+// cache was stopped instead.
+func (cache *Cache) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-cache.wake:
+	case <-cache.stop:
+		return false
+	}
+	return true
+}
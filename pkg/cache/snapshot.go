@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// REPLICATE_MAX_SKEW is how far ahead of the local clock a peer's refresh
+// time may be. Snapshots dated later are refused, as they would never be
+// replaced by a genuine refresh.
+const REPLICATE_MAX_SKEW = time.Minute
+
+// Snapshot is the content of --cacheFile: the records of every cache, so a
+// restart can serve them while AWS can't be reached.
+type Snapshot struct {
+	Caches []*CacheSnapshot
+}
+
+// CacheSnapshot is one cache's records. Records are listed once and
+// referred to by index from Keys, as several keys usually share a record.
+type CacheSnapshot struct {
+	NickName  string
+	Region    string
+	Refreshed time.Time
+	Records   []*Record
+	Keys      []*KeySnapshot
+}
+
+// KeySnapshot is a Key by its subdomain rather than LookupTag, so it
+// survives LookupTags being changed between runs.
+type KeySnapshot struct {
+	Subdomain string `json:",omitempty"`
+	Wildcard  bool   `json:",omitempty"`
+	Value     string
+	Records   []int
+}
+
+// LoadSnapshot reads the snapshot at path. A missing or unreadable snapshot
+// is logged and treated as empty.
+func LoadSnapshot(path string) *Snapshot {
+	snapshot := &Snapshot{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("WARN: %s", err)
+		}
+		return snapshot
+	}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		log.Printf("WARN: ignoring %s: %s", path, err)
+		return &Snapshot{}
+	}
+	return snapshot
+}
+
+// restore fills caches from the snapshot, marking them stale until they
+// are refreshed.
+func (snapshot *Snapshot) restore(caches []*Cache) {
+	for _, saved := range snapshot.Caches {
+		for _, cache := range caches {
+			if cache.awsAccount.NickName != saved.NickName || cache.awsAccount.Region != saved.Region {
+				continue
+			}
+			records := saved.records()
+			for _, record := range saved.Records {
+				if record.MinTTL < STALE_TTL {
+					record.MinTTL = STALE_TTL
+				}
+			}
+
+			cache.setRecords(records)
+			cache.mutex.Lock()
+			cache.refreshed = saved.Refreshed
+			cache.stale = true
+			cache.mutex.Unlock()
+			log.Printf("Restored %d records for %s account in %s from %s", len(records), saved.NickName, saved.Region, saved.Refreshed.Format(time.RFC3339))
+		}
+	}
+}
+
+// records rebuilds the cache's records from the snapshot. Keys for
+// subdomains that are no longer LookupTags are dropped.
+func (saved *CacheSnapshot) records() map[Key][]*Record {
+	records := make(map[Key][]*Record)
+	for _, key := range saved.Keys {
+		tag := LOOKUP_NAME
+		if key.Wildcard {
+			tag = LOOKUP_WILDCARD
+		} else if lookup, ok := SubdomainLookup(key.Subdomain); ok {
+			tag = lookup.LookupTag
+		} else if key.Subdomain != "" {
+			continue
+		}
+		for _, i := range key.Records {
+			if i >= 0 && i < len(saved.Records) {
+				records[Key{tag, key.Value}] = append(records[Key{tag, key.Value}], saved.Records[i])
+			}
+		}
+	}
+	return records
+}
+
+// Replicate serves the records of snapshot, taken by a peer, in place of
+// those of the caches for the same accounts and regions, if the peer
+// refreshed them more recently and the cache can't refresh itself: because
+// its account is Replicated, its refreshes are failing, or the set is
+// following a leader. It returns how many caches were replaced, whose
+// listeners have been notified.
+func (set *CacheSet) Replicate(snapshot *Snapshot) int {
+	following := set.scheduler.following.Load()
+	replaced := 0
+	for _, saved := range snapshot.Caches {
+		for _, cache := range set.All() {
+			if cache.awsAccount.NickName == saved.NickName && cache.awsAccount.Region == saved.Region && cache.replicate(saved, following) {
+				replaced++
+			}
+		}
+	}
+	return replaced
+}
+
+// replicate replaces the cache's records with saved, as Replicate says.
+func (cache *Cache) replicate(saved *CacheSnapshot, following bool) bool {
+	if saved.Refreshed.After(time.Now().Add(REPLICATE_MAX_SKEW)) {
+		log.Printf("WARN: ignoring records for %s account in %s refreshed in the future, at %s", saved.NickName, saved.Region, saved.Refreshed.Format(time.RFC3339))
+		return false
+	}
+	cache.mutex.RLock()
+	replace := saved.Refreshed.After(cache.refreshed) && (following || cache.awsAccount.Replicated || cache.stale || cache.refreshed.IsZero())
+	cache.mutex.RUnlock()
+	if !replace {
+		return false
+	}
+
+	cache.setRecords(saved.records())
+	cache.mutex.Lock()
+	cache.refreshed = saved.Refreshed
+	cache.stale = false
+	if following {
+		// the leader's refresh stands in for the one this server skipped
+		cache.attempted = time.Now()
+	}
+	cache.mutex.Unlock()
+	cache.notify()
+	return true
+}
+
+// snapshotCache captures the cache's current records, stopped ones included.
+func snapshotCache(cache *Cache) *CacheSnapshot {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	saved := &CacheSnapshot{
+		NickName:  cache.awsAccount.NickName,
+		Region:    cache.awsAccount.Region,
+		Refreshed: cache.refreshed,
+	}
+	index := make(map[*Record]int)
+	for key, list := range cache.allRecords() {
+		keySnapshot := &KeySnapshot{
+			Subdomain: SubdomainOf(key.LookupTag),
+			Wildcard:  key.LookupTag == LOOKUP_WILDCARD,
+			Value:     key.Value,
+		}
+		for _, record := range list {
+			i, ok := index[record]
+			if !ok {
+				i = len(saved.Records)
+				index[record] = i
+				saved.Records = append(saved.Records, record)
+			}
+			keySnapshot.Records = append(keySnapshot.Records, i)
+		}
+		saved.Keys = append(saved.Keys, keySnapshot)
+	}
+	return saved
+}
+
+// Snapshot captures the current records of every cache in the set.
+func (set *CacheSet) Snapshot() *Snapshot {
+	snapshot := &Snapshot{}
+	for _, cache := range set.All() {
+		snapshot.Caches = append(snapshot.Caches, snapshotCache(cache))
+	}
+	return snapshot
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"log"
+	"time"
+)
+
+// STALE_TTL is the lowest TTL served for records whose account can't be
+// refreshed, as recommended for serving stale data by RFC 8767, so clients
+// come back for fresh answers soon after the account recovers.
+const STALE_TTL = 30 * time.Second
+
+// markStale keeps serving the cache's last-known-good records after a failed
+// refresh, with their TTL floor raised to STALE_TTL. The records are copied
+// rather than changed in place, as they may be being served concurrently.
+func (cache *Cache) markStale() {
+	cache.mutex.Lock()
+	alreadyStale := cache.stale
+	cache.stale = true
+	records := cache.allRecords()
+	refreshed := cache.refreshed
+	cache.mutex.Unlock()
+
+	if alreadyStale {
+		return
+	}
+	if refreshed.IsZero() {
+		log.Printf("WARN: %s account in %s has never been refreshed, serving no records until it is", cache.awsAccount.NickName, cache.awsAccount.Region)
+	} else {
+		log.Printf("WARN: %s account in %s is stale, serving records from %s", cache.awsAccount.NickName, cache.awsAccount.Region, refreshed.Format(time.RFC3339))
+	}
+
+	copies := make(map[*Record]*Record)
+	stale := make(map[Key][]*Record, len(records))
+	for key, list := range records {
+		for _, record := range list {
+			copied, ok := copies[record]
+			if !ok {
+				copied = new(Record)
+				*copied = *record
+				if copied.MinTTL < STALE_TTL {
+					copied.MinTTL = STALE_TTL
+				}
+				copies[record] = copied
+			}
+			stale[key] = append(stale[key], copied)
+		}
+	}
+	cache.setRecords(stale)
+	cache.notify()
+}
+
+// markFresh records a successful refresh.
+func (cache *Cache) markFresh() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.stale && !cache.refreshed.IsZero() {
+		log.Printf("%s account in %s is fresh again after %s", cache.awsAccount.NickName, cache.awsAccount.Region, time.Since(cache.refreshed).Round(time.Second))
+	}
+	cache.stale = false
+	cache.refreshed = time.Now()
+}
+
+// Staleness is how long the cache has been serving stale records, since its
+// last successful refresh, or 0 if it is fresh.
+func (cache *Cache) Staleness() time.Duration {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	if !cache.stale {
+		return 0
+	}
+	if cache.refreshed.IsZero() {
+		return time.Since(cache.created)
+	}
+	return time.Since(cache.refreshed)
+}
+
+// Attempted is when the cache last finished a refresh, successful or not,
+// or took a leader's records while following, or when it was created if
+// neither has happened yet.
+func (cache *Cache) Attempted() time.Time {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	if cache.attempted.IsZero() {
+		return cache.created
+	}
+	return cache.attempted
+}
+
+// Refreshed is when the cache was last refreshed successfully, zero if never.
+func (cache *Cache) Refreshed() time.Time {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.refreshed
+}
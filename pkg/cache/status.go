@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"log"
+	"time"
+)
+
+// Status is how one account and region's refreshes are going, for the
+// admin API's /status and the periodic summary in the logs.
+type Status struct {
+	Account string
+	Region  string
+	// Records is how many names the cache serves.
+	Records int
+	// LastSuccess and LastAttempt are when the last successful refresh,
+	// and the last refresh of any kind, finished. Nil if there hasn't been
+	// one.
+	LastSuccess *time.Time `json:",omitempty"`
+	LastAttempt *time.Time `json:",omitempty"`
+	// LastError is why the last failed refresh failed, at LastErrorTime,
+	// which may be before LastSuccess.
+	LastError     string     `json:",omitempty"`
+	LastErrorTime *time.Time `json:",omitempty"`
+	// RefreshSeconds is how long the last refresh took.
+	RefreshSeconds float64
+	// Stale is whether the cache serves records from before its last,
+	// failed, refresh, and StaleSeconds for how long.
+	Stale        bool
+	StaleSeconds float64 `json:",omitempty"`
+	// Replicated is whether the records come from peers, see Replicate.
+	Replicated bool `json:",omitempty"`
+}
+
+// recordAttempt notes how a refresh that took duration went.
+func (cache *Cache) recordAttempt(duration time.Duration, err error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.attempted = time.Now()
+	cache.duration = duration
+	if err != nil {
+		cache.lastError, cache.lastErrorTime = err.Error(), cache.attempted
+	}
+}
+
+// Status reports how the cache's refreshes are going.
+func (cache *Cache) Status() Status {
+	staleness := cache.Staleness()
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return Status{
+		Account:        cache.awsAccount.NickName,
+		Region:         cache.awsAccount.Region,
+		Records:        len(cache.records),
+		LastSuccess:    optionalTime(cache.refreshed),
+		LastAttempt:    optionalTime(cache.attempted),
+		LastError:      cache.lastError,
+		LastErrorTime:  optionalTime(cache.lastErrorTime),
+		RefreshSeconds: cache.duration.Seconds(),
+		Stale:          cache.stale,
+		StaleSeconds:   staleness.Seconds(),
+		Replicated:     cache.awsAccount.Replicated,
+	}
+}
+
+// optionalTime is t, or nil if it is zero.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Status reports how the refreshes of every cache in the set are going.
+func (set *CacheSet) Status() []Status {
+	var statuses []Status
+	for _, cache := range set.All() {
+		statuses = append(statuses, cache.Status())
+	}
+	return statuses
+}
+
+// LogStatus logs a summary of the caches every interval, with a warning
+// for each one that is stale, so an account that has been failing for
+// hours is noticed even though the rest keep the server answering.
+func (set *CacheSet) LogStatus(interval time.Duration) {
+	for range time.Tick(interval) {
+		statuses := set.Status()
+		records, stale := 0, 0
+		for _, status := range statuses {
+			records += status.Records
+			if !status.Stale {
+				continue
+			}
+			stale++
+			since := "startup"
+			if status.LastSuccess != nil {
+				since = status.LastSuccess.Format(time.RFC3339)
+			}
+			log.Printf("WARN: %s account in %s has been stale for %s, since %s: %s", status.Account, status.Region, time.Duration(status.StaleSeconds*float64(time.Second)).Round(time.Second), since, status.LastError)
+		}
+		log.Printf("Status: %d accounts and regions, %d stale, serving %d records", len(statuses), stale, records)
+	}
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// INCLUDE_TAGS and EXCLUDE_TAGS limit discovery to the instances tagged with
+// every one of INCLUDE_TAGS and none of EXCLUDE_TAGS, e.g.
+// {"Environment": "prod"} and {"dns": "ignore"}, unless an account sets its
+// own. An EXCLUDE_TAGS value of "*" excludes the tag whatever its value.
+var (
+	INCLUDE_TAGS map[string]string
+	EXCLUDE_TAGS map[string]string
+)
+
+// tagFilteredEC2 limits the instances DescribeInstances returns. include is
+// sent as filters on the request, but EC2 can't filter out tags, so
+// instances with any of exclude are dropped from the response.
+type tagFilteredEC2 struct {
+	EC2API
+	include map[string]string
+	exclude map[string]string
+}
+
+// withTagFilters wraps client to filter instances by include and exclude,
+// unless both are empty.
+func withTagFilters(client EC2API, include, exclude map[string]string) EC2API {
+	if len(include) == 0 && len(exclude) == 0 {
+		return client
+	}
+	return tagFilteredEC2{EC2API: client, include: include, exclude: exclude}
+}
+
+func (client tagFilteredEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	input := *params
+	input.Filters = append([]ec2types.Filter(nil), params.Filters...)
+	for key, value := range client.include {
+		input.Filters = append(input.Filters, ec2types.Filter{Name: aws.String("tag:" + key), Values: []string{value}})
+	}
+	output, err := client.EC2API.DescribeInstances(ctx, &input, optFns...)
+	if err != nil || len(client.exclude) == 0 {
+		return output, err
+	}
+
+	for i := range output.Reservations {
+		reservation := &output.Reservations[i]
+		kept := reservation.Instances[:0]
+		for _, instance := range reservation.Instances {
+			if !client.excluded(instance.Tags) {
+				kept = append(kept, instance)
+			}
+		}
+		reservation.Instances = kept
+	}
+	return output, nil
+}
+
+// excluded returns whether tags include any of the excluded tags.
+func (client tagFilteredEC2) excluded(tags []ec2types.Tag) bool {
+	for _, tag := range tags {
+		if value, ok := client.exclude[aws.ToString(tag.Key)]; ok && (value == "*" || value == aws.ToString(tag.Value)) {
+			return true
+		}
+	}
+	return false
+}
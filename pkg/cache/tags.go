@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// PUBLIC_LABEL starts the names of public lookups, pub.<name>.<domain>, so
+// no name can be it: pub.role.<domain> would be taken for the public lookup
+// of role.<domain>.
+const PUBLIC_LABEL = "pub"
+
+// withoutPublicLabel drops the names that are PUBLIC_LABEL from records,
+// with a warning.
+func (cache *Cache) withoutPublicLabel(records map[Key][]*Record) map[Key][]*Record {
+	for key := range records {
+		if key.Value == PUBLIC_LABEL {
+			log.Printf("WARN: %s account in %s: not serving %s, as %s. starts public lookups", cache.awsAccount.NickName, cache.awsAccount.Region, KeyName(key, "<domain>"), PUBLIC_LABEL)
+			delete(records, key)
+		}
+	}
+	return records
+}
+
+// TagLookup describes a subdomain whose names come from an instance tag,
+// e.g. instances tagged Role=web are served as web.role.<domain>.
+type TagLookup struct {
+	LookupTag LookupTag
+	// Tag is the instance tag key the values are read from.
+	Tag string
+	// Subdomain is the label (or labels) the names are served under, empty for the top level.
+	Subdomain string
+}
+
+// tagLookups are all the subdomains, mostly from instance tags. Those with
+// no Tag are filled in by other AWS services.
+var tagLookups = []*TagLookup{
+	{LookupTag: LOOKUP_NAME, Tag: "Name"},
+	{LookupTag: LOOKUP_ROLE, Tag: "Role", Subdomain: "role"},
+	{LookupTag: LOOKUP_READER, Subdomain: "ro"},
+	{LookupTag: LOOKUP_LB, Subdomain: "lb"},
+	{LookupTag: LOOKUP_CACHE, Subdomain: "cache"},
+	{LookupTag: LOOKUP_CACHE_READER, Subdomain: "ro.cache"},
+	{LookupTag: LOOKUP_ECS, Subdomain: "ecs"},
+	{LookupTag: LOOKUP_EKS, Tag: EKS_NODEGROUP_TAG, Subdomain: "eks"},
+	{LookupTag: LOOKUP_EFS, Subdomain: "efs"},
+	{LookupTag: LOOKUP_MSK, Subdomain: "msk"},
+	{LookupTag: LOOKUP_EIP, Subdomain: "eip"},
+	{LookupTag: LOOKUP_NAT, Subdomain: "nat"},
+	{LookupTag: LOOKUP_VPCE, Subdomain: "vpce"},
+	{LookupTag: LOOKUP_ASG, Subdomain: "asg"},
+}
+
+// SetTagKey changes which instance tag a built in lookup reads its values
+// from, e.g. to use Hostname instead of Name.
+func SetTagKey(tag LookupTag, key string) {
+	for _, lookup := range tagLookups {
+		if lookup.LookupTag == tag {
+			lookup.Tag = key
+		}
+	}
+}
+
+// AddTagLookups registers extra tag to subdomain mappings from the config file,
+// e.g. {"Team": "team"} serves instances tagged Team=infra as infra.team.<domain>.
+func AddTagLookups(mappings map[string]string) error {
+	var tags []string
+	for tag := range mappings {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	for _, tag := range tags {
+		subdomain := mappings[tag]
+		if subdomain == "" || Sanitize(subdomain) != subdomain {
+			return fmt.Errorf("LookupTags: %q is not a valid subdomain for %s", subdomain, tag)
+		}
+		if _, ok := SubdomainLookup(subdomain); ok {
+			return fmt.Errorf("LookupTags: subdomain %q is already in use", subdomain)
+		}
+		custom := 0
+		for _, lookup := range tagLookups {
+			if lookup.LookupTag >= LOOKUP_CUSTOM {
+				custom++
+			}
+		}
+		tagLookups = append(tagLookups, &TagLookup{
+			LookupTag: LOOKUP_CUSTOM + LookupTag(custom),
+			Tag:       tag,
+			Subdomain: subdomain,
+		})
+	}
+	return nil
+}
+
+// TagLookups returns the subdomains and the tags their names come from.
+// The slice must not be modified.
+func TagLookups() []*TagLookup {
+	return tagLookups
+}
+
+// SubdomainLookup finds the TagLookup served under subdomain.
+func SubdomainLookup(subdomain string) (*TagLookup, bool) {
+	for _, lookup := range tagLookups {
+		if lookup.Subdomain != "" && lookup.Subdomain == subdomain {
+			return lookup, true
+		}
+	}
+	return nil, false
+}
+
+// SubdomainOf returns the subdomain a LookupTag is served under.
+func SubdomainOf(tag LookupTag) string {
+	for _, lookup := range tagLookups {
+		if lookup.LookupTag == tag {
+			return lookup.Subdomain
+		}
+	}
+	return ""
+}
+
+// KeyName is the fully qualified name that key is served under.
+func KeyName(key Key, domain string) string {
+	if subdomain := SubdomainOf(key.LookupTag); subdomain != "" {
+		return key.Value + "." + subdomain + "." + domain
+	}
+	if key.LookupTag == LOOKUP_WILDCARD {
+		return "*." + key.Value + "." + domain
+	}
+	return key.Value + "." + domain
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"log"
+	"time"
+)
+
+// TERMINATING_HOLD is how long an instance reported as terminating is left
+// out of answers, longer than it can take to actually terminate.
+const TERMINATING_HOLD = time.Hour
+
+// Terminating stops serving instanceID immediately, and keeps it out of the
+// cache for TERMINATING_HOLD even while AWS still reports it running. It
+// returns false if the instance isn't in this cache.
+func (cache *Cache) Terminating(instanceID string) bool {
+	if len(cache.Lookup(LOOKUP_NAME, instanceID)) == 0 {
+		return false
+	}
+
+	// filtered under the lock, so a refresh can't land in between and be
+	// overwritten with the records from before it
+	cache.mutex.Lock()
+	if cache.terminating == nil {
+		cache.terminating = make(map[string]time.Time)
+	}
+	cache.terminating[instanceID] = time.Now().Add(TERMINATING_HOLD)
+	cache.storeRecords(cache.allRecords())
+	cache.mutex.Unlock()
+
+	log.Printf("Removing terminating instance %s from %s account in %s", instanceID, cache.awsAccount.NickName, cache.awsAccount.Region)
+	cache.notify()
+	return true
+}
+
+// withoutTerminating returns records without the instances that are
+// terminating, forgetting those whose hold has expired. cache.mutex must be
+// held.
+func (cache *Cache) withoutTerminating(records map[Key][]*Record) map[Key][]*Record {
+	now := time.Now()
+	terminating := make(map[string]bool)
+	for id, until := range cache.terminating {
+		if now.After(until) {
+			delete(cache.terminating, id)
+		} else {
+			terminating[id] = true
+		}
+	}
+
+	if len(terminating) == 0 {
+		return records
+	}
+	filtered := make(map[Key][]*Record, len(records))
+	for key, list := range records {
+		var kept []*Record
+		for _, record := range list {
+			if !terminating[record.InstanceID] {
+				kept = append(kept, record)
+			}
+		}
+		if len(kept) > 0 {
+			filtered[key] = kept
+		}
+	}
+	return filtered
+}
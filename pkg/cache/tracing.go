@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TRACER_NAME identifies the refresh spans.
+const TRACER_NAME = "github.com/foreflight/aws-name-server/pkg/cache"
+
+// tracer creates the refresh spans. It is a no-op until a TracerProvider
+// is set with otel.SetTracerProvider.
+var tracer = otel.Tracer(TRACER_NAME)
+
+// startRefreshSpan starts the span for a refresh of cache. The AWS calls
+// made with the returned context are its children.
+func startRefreshSpan(ctx context.Context, cache *Cache) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "refresh", trace.WithAttributes(
+		attribute.String("aws.account", cache.awsAccount.NickName),
+		attribute.String("aws.region", cache.awsAccount.Region),
+	))
+}
+
+// endRefreshSpan ends the span for a refresh that returned err.
+func endRefreshSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,73 @@
+package dnsserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseCIDRs parses a list of subnets such as 10.0.0.0/8. A bare IP address
+// is treated as a single host.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var subnets []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %s", cidr, err)
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+// addrIP extracts the IP address from a client's net.Addr.
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+// forwardAllowed returns whether queries from a client at remote may be
+// forwarded to the Upstreams. Without AllowedSubnets only private and
+// loopback clients may, so --forward doesn't turn a server reachable from
+// the internet into an open resolver.
+func (s *NameServer) forwardAllowed(remote net.Addr) bool {
+	if len(s.AllowedSubnets) > 0 {
+		return s.allowed(remote)
+	}
+	ip := addrIP(remote)
+	return ip != nil && (ip.IsPrivate() || ip.IsLoopback())
+}
+
+// allowed returns whether a client at remote may query the server. Every
+// client is allowed if no subnets are configured.
+func (s *NameServer) allowed(remote net.Addr) bool {
+	if len(s.AllowedSubnets) == 0 {
+		return true
+	}
+	ip := addrIP(remote)
+	if ip == nil {
+		return false
+	}
+	for _, subnet := range s.AllowedSubnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package dnsserver
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// lowerCase is the question with its name lower cased, as names are
+// matched. Resolvers randomize the case of the names they ask for (DNS
+// 0x20) to make spoofed answers harder, so the answer echoes their case
+// back, see withCase.
+func lowerCase(question dns.Question) dns.Question {
+	question.Name = strings.ToLower(question.Name)
+	return question
+}
+
+// withCase gives the records in rrs owned by name, a lower cased question
+// name, the case it was asked with. They are copied, as records may be
+// shared with other answers.
+func withCase(rrs []dns.RR, name string, asked string) {
+	if name == asked {
+		return
+	}
+	for i, rr := range rrs {
+		if rr.Header().Name == name {
+			rrs[i] = dns.Copy(rr)
+			rrs[i].Header().Name = asked
+		}
+	}
+}
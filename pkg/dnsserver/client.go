@@ -0,0 +1,114 @@
+package dnsserver
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// Client describes who a query came from, which can change the answer.
+type Client struct {
+	// IP is the client's address, or the subnet from its EDNS Client Subnet option.
+	IP net.IP
+	// View chooses between private and public addresses.
+	View *View
+	// Zone is the availability zone the client is in, if known.
+	Zone string
+
+	subnet *dns.EDNS0_SUBNET
+}
+
+// client works out who request, received from remote, is for.
+func (s *NameServer) client(request *dns.Msg, remote net.Addr) *Client {
+	client := &Client{
+		IP:   addrIP(remote),
+		View: s.view(remote),
+	}
+
+	if opt := request.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			if subnet, ok := option.(*dns.EDNS0_SUBNET); ok {
+				client.subnet = subnet
+				client.IP = subnet.Address
+			}
+		}
+	}
+
+	if client.IP != nil {
+		for _, cache := range s.caches.All() {
+			if zone := cache.ZoneOf(client.IP); zone != "" {
+				client.Zone = zone
+				break
+			}
+		}
+	}
+	return client
+}
+
+// subnetOption is the ECS option to return to the client, with the scope set
+// to say the answer is valid for the client's whole subnet.
+func (client *Client) subnetOption() *dns.EDNS0_SUBNET {
+	if client.subnet == nil {
+		return nil
+	}
+	return &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        client.subnet.Family,
+		SourceNetmask: client.subnet.SourceNetmask,
+		SourceScope:   client.subnet.SourceNetmask,
+		Address:       client.subnet.Address,
+	}
+}
+
+// zoneLocal drops ZoneLocal records outside the client's availability zone,
+// as long as there is at least one inside it.
+func (client *Client) zoneLocal(records []*cache.Record) []*cache.Record {
+	if client.Zone == "" {
+		return records
+	}
+
+	var local []*cache.Record
+	for _, record := range records {
+		if !record.ZoneLocal {
+			return records
+		}
+		if record.AvailabilityZone == client.Zone {
+			local = append(local, record)
+		}
+	}
+	if len(local) == 0 {
+		return records
+	}
+	return local
+}
+
+// sortByTopology orders records so those in the client's availability zone
+// come first, then those in its region, then everything else.
+func (client *Client) sortByTopology(records []*cache.Record) []*cache.Record {
+	if client.Zone == "" || len(records) < 2 {
+		return records
+	}
+
+	distance := func(record *cache.Record) int {
+		switch {
+		case record.AvailabilityZone == client.Zone:
+			return 0
+		case record.AvailabilityZone != "" && region(record.AvailabilityZone) == region(client.Zone):
+			return 1
+		}
+		return 2
+	}
+
+	sorted := append([]*cache.Record(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool { return distance(sorted[i]) < distance(sorted[j]) })
+	return sorted
+}
+
+// region strips the zone letter from an availability zone, us-east-1a => us-east-1.
+func region(zone string) string {
+	return strings.TrimRight(zone, "abcdefghijklmnopqrstuvwxyz")
+}
@@ -0,0 +1,30 @@
+package dnsserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// ParseCNAMEs turns the CNAMEs in the config file, names in the domain
+// such as api-gateway or api.partner mapped to their targets, into records.
+// They are served with --ttl.
+func ParseCNAMEs(cnames map[string]string) (map[string]*cache.Record, error) {
+	records := make(map[string]*cache.Record, len(cnames))
+	for name, target := range cnames {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || cache.Sanitize(label) != label {
+				return nil, fmt.Errorf("CNAMEs: %q is not a valid name", name)
+			}
+		}
+		if _, ok := dns.IsDomainName(target); !ok || target == "" {
+			return nil, fmt.Errorf("CNAMEs: %q is not a valid target for %s", target, name)
+		}
+		records[name] = &cache.Record{Name: name, CName: dns.Fqdn(target), MinTTL: cache.TTL}
+	}
+	return records, nil
+}
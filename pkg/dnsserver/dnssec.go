@@ -0,0 +1,179 @@
+package dnsserver
+
+import (
+	"crypto"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SIGNATURE_VALIDITY is how long RRSIGs produced by online signing are valid for.
+// Signatures are backdated by the same amount to allow for clock skew.
+const SIGNATURE_VALIDITY = 24 * time.Hour
+
+// Signer holds the keys used to sign the zone online.
+type Signer struct {
+	ksk     *dns.DNSKEY
+	kskPriv crypto.Signer
+	zsk     *dns.DNSKEY
+	zskPriv crypto.Signer
+}
+
+// NewSigner loads a key-signing key and zone-signing key from BIND-style
+// K<zone>+<alg>+<tag> file pairs (as written by dnssec-keygen). If zskFile is
+// empty the KSK is used for everything.
+func NewSigner(kskFile, zskFile string) (*Signer, error) {
+	ksk, kskPriv, err := readKey(kskFile)
+	if err != nil {
+		return nil, err
+	}
+	signer := &Signer{ksk: ksk, kskPriv: kskPriv, zsk: ksk, zskPriv: kskPriv}
+
+	if zskFile != "" {
+		if signer.zsk, signer.zskPriv, err = readKey(zskFile); err != nil {
+			return nil, err
+		}
+	}
+	return signer, nil
+}
+
+func readKey(base string) (*dns.DNSKEY, crypto.Signer, error) {
+	public, err := os.Open(base + ".key")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer public.Close()
+
+	rr, err := dns.ReadRR(public, base+".key")
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s.key does not contain a DNSKEY", base)
+	}
+
+	private, err := os.Open(base + ".private")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer private.Close()
+
+	priv, err := key.ReadPrivateKey(private, base+".private")
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s.private is not a signing key", base)
+	}
+	return key, signer, nil
+}
+
+// DS returns the DS record to publish in the parent zone.
+func (signer *Signer) DS() *dns.DS {
+	return signer.ksk.ToDS(dns.SHA256)
+}
+
+// DNSKEY returns the zone's DNSKEY RRset.
+func (signer *Signer) DNSKEY() []dns.RR {
+	keys := []dns.RR{signer.ksk}
+	if signer.zsk != signer.ksk {
+		keys = append(keys, signer.zsk)
+	}
+	return keys
+}
+
+// Sign appends RRSIGs to every RRset in rrs that belongs to domain.
+func (signer *Signer) Sign(domain string, rrs []dns.RR) []dns.RR {
+	var signed []dns.RR
+	for _, rrset := range rrsets(rrs) {
+		signed = append(signed, rrset...)
+		if !dns.IsSubDomain(domain, rrset[0].Header().Name) {
+			continue
+		}
+
+		key, priv := signer.zsk, signer.zskPriv
+		if rrset[0].Header().Rrtype == dns.TypeDNSKEY {
+			key, priv = signer.ksk, signer.kskPriv
+		}
+
+		now := time.Now()
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrset[0].Header().Ttl},
+			Algorithm:  key.Algorithm,
+			KeyTag:     key.KeyTag(),
+			SignerName: domain,
+			Inception:  uint32(now.Add(-SIGNATURE_VALIDITY).Unix()),
+			Expiration: uint32(now.Add(SIGNATURE_VALIDITY).Unix()),
+		}
+		if err := sig.Sign(priv, rrset); err != nil {
+			log.Printf("ERROR: signing %s %s: %s", rrset[0].Header().Name, dns.TypeToString[rrset[0].Header().Rrtype], err)
+			continue
+		}
+		signed = append(signed, sig)
+	}
+	return signed
+}
+
+// NSEC returns a minimal "black lies" NSEC record proving that name has no
+// data of any type but types, without having to walk the whole zone.
+func (signer *Signer) NSEC(name string, ttl uint32, types []uint16) dns.RR {
+	bitmap := append([]uint16{dns.TypeRRSIG, dns.TypeNSEC}, types...)
+	sort.Slice(bitmap, func(i, j int) bool { return bitmap[i] < bitmap[j] })
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl},
+		NextDomain: "\\000." + name,
+		TypeBitMap: bitmap,
+	}
+}
+
+// NSEC_TYPES are the types the server may answer with records of its own,
+// rather than StaticRecords, which are checked for by types.
+var NSEC_TYPES = []uint16{dns.TypeA, dns.TypeNS, dns.TypeSOA, dns.TypePTR, dns.TypeSRV, dns.TypeDNSKEY}
+
+// types finds the types of the records at the name in msg, as answered to
+// client, for the bitmap of its NSEC record. Validators that synthesize
+// answers from NSEC records, as in RFC 8198, would otherwise take a NODATA
+// for one type to mean the name has none.
+func (s *NameServer) types(msg dns.Question, client *Client) []uint16 {
+	found := make(map[uint16]bool)
+	for _, qtype := range NSEC_TYPES {
+		question := msg
+		question.Qtype = qtype
+		for _, rr := range s.answer(question, client) {
+			if rr.Header().Name == msg.Name {
+				found[rr.Header().Rrtype] = true
+			}
+		}
+	}
+	for _, rr := range s.StaticRecords[msg.Name] {
+		found[rr.Header().Rrtype] = true
+	}
+
+	types := make([]uint16, 0, len(found))
+	for rrtype := range found {
+		types = append(types, rrtype)
+	}
+	return types
+}
+
+// rrsets groups rrs by owner name and type, preserving order.
+func rrsets(rrs []dns.RR) [][]dns.RR {
+	var sets [][]dns.RR
+	index := make(map[string]int)
+	for _, rr := range rrs {
+		key := rr.Header().Name + "/" + dns.TypeToString[rr.Header().Rrtype]
+		if i, ok := index[key]; ok {
+			sets[i] = append(sets[i], rr)
+			continue
+		}
+		index[key] = len(sets)
+		sets = append(sets, []dns.RR{rr})
+	}
+	return sets
+}
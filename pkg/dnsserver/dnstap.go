@@ -0,0 +1,149 @@
+package dnsserver
+
+import (
+	"log"
+	"net"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/miekg/dns"
+	"google.golang.org/protobuf/proto"
+)
+
+// DNSTAP_TIMEOUT is how long to wait writing to the --dnstapSocket reader
+// before reconnecting. Frames are dropped rather than slowing queries down.
+const DNSTAP_TIMEOUT = 5 * time.Second
+
+// Tap sends dnstap frames for every query and response to a Unix socket,
+// in the same format unbound and BIND do.
+type Tap struct {
+	output   *dnstap.FrameStreamSockOutput
+	identity []byte
+}
+
+// NewTap connects to the dnstap reader listening on socket, identifying
+// this server as identity. It reconnects if the reader restarts.
+func NewTap(socket string, identity string) (*Tap, error) {
+	output, err := dnstap.NewFrameStreamSockOutput(&net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	output.SetTimeout(DNSTAP_TIMEOUT)
+	go output.RunOutputLoop()
+	return &Tap{output: output, identity: []byte(identity)}, nil
+}
+
+// writer sends request's query frame, and returns a ResponseWriter that
+// sends the response frame when the answer is written to w.
+func (tap *Tap) writer(w dns.ResponseWriter, request *dns.Msg) dns.ResponseWriter {
+	if tap == nil {
+		return w
+	}
+	protocol := "udp"
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		protocol = "tcp"
+	}
+	start := time.Now()
+	if packed, err := request.Pack(); err == nil {
+		tap.query(packed, w.RemoteAddr(), protocol, start)
+	}
+	return &tapWriter{ResponseWriter: w, tap: tap, protocol: protocol, start: start}
+}
+
+// query sends the frame for a query packed, received from remote over
+// protocol (udp, tcp or doh) at start.
+func (tap *Tap) query(packed []byte, remote net.Addr, protocol string, start time.Time) {
+	if tap == nil {
+		return
+	}
+	message := tap.message(dnstap.Message_AUTH_QUERY, remote, protocol, start)
+	message.QueryMessage = packed
+	tap.send(message)
+}
+
+// response sends the frame for the response packed to a query received
+// from remote over protocol at start.
+func (tap *Tap) response(packed []byte, remote net.Addr, protocol string, start time.Time) {
+	if tap == nil {
+		return
+	}
+	message := tap.message(dnstap.Message_AUTH_RESPONSE, remote, protocol, start)
+	now := time.Now()
+	message.ResponseTimeSec = proto.Uint64(uint64(now.Unix()))
+	message.ResponseTimeNsec = proto.Uint32(uint32(now.Nanosecond()))
+	message.ResponseMessage = packed
+	tap.send(message)
+}
+
+// message fills in the parts of a frame common to queries and responses.
+func (tap *Tap) message(messageType dnstap.Message_Type, remote net.Addr, protocol string, start time.Time) *dnstap.Message {
+	message := &dnstap.Message{
+		Type:           messageType.Enum(),
+		SocketProtocol: dnstap.SocketProtocol_UDP.Enum(),
+		QueryTimeSec:   proto.Uint64(uint64(start.Unix())),
+		QueryTimeNsec:  proto.Uint32(uint32(start.Nanosecond())),
+	}
+	switch protocol {
+	case "tcp":
+		message.SocketProtocol = dnstap.SocketProtocol_TCP.Enum()
+	case "doh":
+		message.SocketProtocol = dnstap.SocketProtocol_DOH.Enum()
+	}
+
+	var ip net.IP
+	var port int
+	switch addr := remote.(type) {
+	case *net.UDPAddr:
+		ip, port = addr.IP, addr.Port
+	case *net.TCPAddr:
+		ip, port = addr.IP, addr.Port
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		message.SocketFamily = dnstap.SocketFamily_INET.Enum()
+		message.QueryAddress = ip4
+	} else if ip != nil {
+		message.SocketFamily = dnstap.SocketFamily_INET6.Enum()
+		message.QueryAddress = ip
+	}
+	if ip != nil {
+		message.QueryPort = proto.Uint32(uint32(port))
+	}
+	return message
+}
+
+// send queues message for the reader, dropping it if the queue is full.
+func (tap *Tap) send(message *dnstap.Message) {
+	frame, err := proto.Marshal(&dnstap.Dnstap{
+		Type:     dnstap.Dnstap_MESSAGE.Enum(),
+		Identity: tap.identity,
+		Message:  message,
+	})
+	if err != nil {
+		log.Printf("ERROR: dnstap: %s", err)
+		return
+	}
+	select {
+	case tap.output.GetOutputChannel() <- frame:
+	default:
+	}
+}
+
+// tapWriter sends the frame for each response written.
+type tapWriter struct {
+	dns.ResponseWriter
+	tap      *Tap
+	protocol string
+	start    time.Time
+}
+
+func (writer *tapWriter) WriteMsg(msg *dns.Msg) error {
+	if packed, err := msg.Pack(); err == nil {
+		writer.tap.response(packed, writer.RemoteAddr(), writer.protocol, writer.start)
+	}
+	return writer.ResponseWriter.WriteMsg(msg)
+}
+
+func (writer *tapWriter) Write(packed []byte) (int, error) {
+	writer.tap.response(packed, writer.RemoteAddr(), writer.protocol, writer.start)
+	return writer.ResponseWriter.Write(packed)
+}
@@ -0,0 +1,155 @@
+package dnsserver
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DOH_PATH is the well-known path for DNS-over-HTTPS queries (RFC 8484).
+const DOH_PATH = "/dns-query"
+
+// DOH_MEDIA_TYPE is the content type of DNS wire format messages over HTTPS.
+const DOH_MEDIA_TYPE = "application/dns-message"
+
+// ListenAndServeHTTPS answers DNS-over-HTTPS queries on address. If certFile
+// and keyFile are empty it serves plain HTTP, for use behind a TLS terminating
+// load balancer.
+func (s *NameServer) ListenAndServeHTTPS(address, certFile, keyFile string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(DOH_PATH, s.handleHTTPS)
+	server := &http.Server{Addr: address, Handler: mux}
+	s.serving(server)
+
+	var err error
+	if certFile == "" && keyFile == "" {
+		log.Printf("WARN: serving DNS-over-HTTPS on %s without TLS", address)
+		err = server.ListenAndServe()
+	} else {
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	}
+	if err != http.ErrServerClosed {
+		log.Fatalf("%s", err)
+	}
+}
+
+func (s *NameServer) handleHTTPS(w http.ResponseWriter, req *http.Request) {
+	var packed []byte
+	var err error
+
+	switch req.Method {
+	case http.MethodGet:
+		packed, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+	case http.MethodPost:
+		if req.Header.Get("Content-Type") != DOH_MEDIA_TYPE {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		packed, err = ioutil.ReadAll(http.MaxBytesReader(w, req.Body, dns.MaxMsgSize))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil || len(packed) == 0 {
+		http.Error(w, "missing or malformed dns parameter", http.StatusBadRequest)
+		return
+	}
+
+	request := new(dns.Msg)
+	if err := request.Unpack(packed); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var remote net.Addr = &net.TCPAddr{}
+	if addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr); err == nil {
+		remote = addr
+	}
+
+	start := time.Now()
+	s.Tap.query(packed, remote, "doh", start)
+
+	// answered like queries over TCP, with the same checks
+	doh := &dohWriter{remote: remote, tsig: s.verifyTSIG(request, packed)}
+	if len(request.Question) == 1 && (request.Question[0].Qtype == dns.TypeAXFR || request.Question[0].Qtype == dns.TypeIXFR) {
+		// a transfer takes several messages, and DNS-over-HTTPS sends one
+		log.Printf("WARN: refusing %v (id=%v): zone transfers need TCP", remote, request.Id)
+		doh.WriteMsg(new(dns.Msg).SetRcode(request, dns.RcodeRefused))
+	} else if len(request.Question) > 0 && len(s.Upstreams) > 0 && !s.inZone(request.Question[0].Name) {
+		s.handleForward(doh, request)
+	} else {
+		s.handleRequest(doh, request)
+	}
+
+	response := doh.response
+	if doh.packed != nil {
+		// answered from the ResponseCache
+		packed, response = doh.packed, new(dns.Msg)
+		err = response.Unpack(packed)
+	} else if doh.tsig != nil {
+		// refused, and can't be signed with a key that didn't verify
+		packed, err = response.Pack()
+	} else {
+		packed, err = s.packTSIG(request, response)
+	}
+	if err != nil {
+		log.Printf("ERROR: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", DOH_MEDIA_TYPE)
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(minTTL(response)))
+	w.Write(packed)
+	s.Tap.response(packed, remote, "doh", start)
+	observeQuery(request, response.Rcode, start)
+}
+
+// dohWriter is the dns.ResponseWriter DNS-over-HTTPS queries are answered
+// with. It keeps the message written, for handleHTTPS to sign and send.
+type dohWriter struct {
+	remote   net.Addr
+	tsig     error
+	response *dns.Msg
+	packed   []byte
+}
+
+func (w *dohWriter) LocalAddr() net.Addr  { return &net.TCPAddr{} }
+func (w *dohWriter) RemoteAddr() net.Addr { return w.remote }
+func (w *dohWriter) TsigStatus() error    { return w.tsig }
+func (w *dohWriter) TsigTimersOnly(bool)  {}
+func (w *dohWriter) Hijack()              {}
+func (w *dohWriter) Close() error         { return nil }
+
+func (w *dohWriter) WriteMsg(msg *dns.Msg) error {
+	w.response = msg
+	return nil
+}
+
+func (w *dohWriter) Write(packed []byte) (int, error) {
+	w.packed = packed
+	return len(packed), nil
+}
+
+// minTTL returns the smallest TTL in response, which is how long HTTP
+// caches may keep it.
+func minTTL(response *dns.Msg) int {
+	min := -1
+	for _, section := range [][]dns.RR{response.Answer, response.Ns} {
+		for _, rr := range section {
+			if ttl := int(rr.Header().Ttl); min == -1 || ttl < min {
+				min = ttl
+			}
+		}
+	}
+	if min == -1 {
+		return 0
+	}
+	return min
+}
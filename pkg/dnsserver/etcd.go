@@ -0,0 +1,142 @@
+package dnsserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// ETCD_TIMEOUT bounds each push to etcd.
+const ETCD_TIMEOUT = 30 * time.Second
+
+// EtcdSync writes the zone into etcd in the key format of SkyDNS and the
+// CoreDNS etcd plugin, so CoreDNS can serve the names without a delegation.
+// web.aws.example.com is written under <prefix>/com/example/aws/web/, with
+// a key per address. Only keys it has written itself are ever changed or
+// deleted.
+type EtcdSync struct {
+	prefix string
+	client *clientv3.Client
+	pushed map[string]string
+}
+
+// skyDNSService is the value SkyDNS and CoreDNS read from each key. A host
+// that isn't an IP address is served as a CNAME.
+type skyDNSService struct {
+	Host string `json:"host"`
+	TTL  uint32 `json:"ttl,omitempty"`
+}
+
+// NewEtcdSync creates an EtcdSync for the etcd cluster at endpoints, writing
+// under prefix, e.g. /skydns.
+func NewEtcdSync(endpoints []string, prefix string) (*EtcdSync, error) {
+	client, err := clientv3.New(clientv3.Config{Endpoints: endpoints, DialTimeout: ETCD_TIMEOUT})
+	if err != nil {
+		return nil, err
+	}
+	return &EtcdSync{
+		prefix: "/" + strings.Trim(prefix, "/"),
+		client: client,
+		pushed: make(map[string]string),
+	}, nil
+}
+
+// Push writes the keys for rrs that changed since the last push, and
+// deletes those that have gone.
+func (e *EtcdSync) Push(rrs []dns.RR) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ETCD_TIMEOUT)
+	defer cancel()
+
+	keys := e.keys(rrs)
+	changes := 0
+	for key, value := range keys {
+		if e.pushed[key] == value {
+			continue
+		}
+		if _, err := e.client.Put(ctx, key, value); err != nil {
+			return err
+		}
+		e.pushed[key] = value
+		changes++
+	}
+	for key := range e.pushed {
+		if _, ok := keys[key]; ok {
+			continue
+		}
+		if _, err := e.client.Delete(ctx, key); err != nil {
+			return err
+		}
+		delete(e.pushed, key)
+		changes++
+	}
+	if changes > 0 {
+		log.Printf("Pushed %d changes to etcd under %s", changes, e.prefix)
+	}
+	return nil
+}
+
+// keys are the etcd keys and values for the A and CNAME records in rrs.
+// Wildcards can't be expressed in SkyDNS's format, so are left out.
+func (e *EtcdSync) keys(rrs []dns.RR) map[string]string {
+	keys := make(map[string]string)
+	for _, rr := range rrs {
+		var host string
+		switch rr := rr.(type) {
+		case *dns.A:
+			host = rr.A.String()
+		case *dns.CNAME:
+			host = strings.TrimSuffix(rr.Target, ".")
+		default:
+			continue
+		}
+		name := rr.Header().Name
+		if strings.HasPrefix(name, "*.") {
+			continue
+		}
+
+		value, err := json.Marshal(&skyDNSService{Host: host, TTL: rr.Header().Ttl})
+		if err != nil {
+			continue
+		}
+		// each address gets its own key under the name, stable between pushes
+		hash := fnv.New32a()
+		hash.Write([]byte(host))
+		keys[fmt.Sprintf("%s/%x", e.path(name), hash.Sum32())] = string(value)
+	}
+	return keys
+}
+
+// path is the etcd key for name, its labels reversed under the prefix.
+func (e *EtcdSync) path(name string) string {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return e.prefix + "/" + strings.Join(labels, "/")
+}
+
+// PushEtcd writes the current zone into etcd, e.g. as soon as Etcd is set
+// rather than at the next change.
+func (s *NameServer) PushEtcd() {
+	s.zoneMutex.Lock()
+	defer s.zoneMutex.Unlock()
+	s.pushEtcd()
+}
+
+// pushEtcd writes the current zone into etcd, if configured.
+func (s *NameServer) pushEtcd() {
+	if s.Etcd == nil {
+		return
+	}
+	_, rrs := s.journal.Snapshot()
+	if err := s.Etcd.Push(rrs); err != nil {
+		log.Printf("ERROR: pushing to etcd under %s: %s", s.Etcd.prefix, err)
+	}
+}
@@ -0,0 +1,37 @@
+package dnsserver
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// FLATTEN_TIMEOUT bounds how long resolving a CNAME target can delay an answer.
+const FLATTEN_TIMEOUT = 2 * time.Second
+
+// flatten resolves target and returns A records for it named name, so clients
+// that can't follow CNAMEs out of the zone still get an address.
+func flatten(name, target string, ttl uint32) []dns.RR {
+	ctx, cancel := context.WithTimeout(context.Background(), FLATTEN_TIMEOUT)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target)
+	if err != nil {
+		log.Printf("WARN: can't flatten %s: %s", target, err)
+		return nil
+	}
+
+	var answers []dns.RR
+	for _, addr := range addrs {
+		if ip := addr.IP.To4(); ip != nil {
+			answers = append(answers, &dns.A{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   ip,
+			})
+		}
+	}
+	return answers
+}
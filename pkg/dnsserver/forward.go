@@ -0,0 +1,81 @@
+package dnsserver
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// FORWARD_TIMEOUT bounds how long we wait for each upstream resolver.
+const FORWARD_TIMEOUT = 2 * time.Second
+
+// ParseUpstreams adds the default DNS port to upstream resolvers given without one.
+func ParseUpstreams(upstreams []string) []string {
+	var addresses []string
+	for _, upstream := range upstreams {
+		upstream = strings.TrimSpace(upstream)
+		if upstream == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(upstream, "53")
+		}
+		addresses = append(addresses, upstream)
+	}
+	return addresses
+}
+
+// handleForward proxies queries outside our zone to the upstream resolvers.
+func (s *NameServer) handleForward(w dns.ResponseWriter, request *dns.Msg) {
+	if !s.forwardAllowed(w.RemoteAddr()) {
+		log.Printf("WARN: refusing to forward for %v (id=%v): not in an allowed subnet", w.RemoteAddr(), request.Id)
+		w.WriteMsg(new(dns.Msg).SetRcode(request, dns.RcodeRefused))
+		return
+	}
+	if request.IsTsig() != nil {
+		log.Printf("WARN: refusing to forward for %v (id=%v): upstreams can't verify TSIG", w.RemoteAddr(), request.Id)
+		w.WriteMsg(new(dns.Msg).SetRcode(request, dns.RcodeRefused))
+		return
+	}
+
+	network := "udp"
+	if _, tcp := w.RemoteAddr().(*net.TCPAddr); tcp {
+		network = "tcp"
+	}
+
+	r, err := s.forward(request, network)
+	if err != nil {
+		log.Printf("ERROR: forwarding %v (id=%v): %s", request.Question, request.Id, err)
+		r = new(dns.Msg).SetRcode(request, dns.RcodeServerFailure)
+	}
+	r.RecursionAvailable = true
+	w.WriteMsg(r)
+}
+
+// forward sends request to each upstream resolver in turn until one answers.
+func (s *NameServer) forward(request *dns.Msg, network string) (*dns.Msg, error) {
+	if len(s.Upstreams) == 0 {
+		return nil, errors.New("no upstream resolvers configured")
+	}
+
+	client := &dns.Client{Net: network, Timeout: FORWARD_TIMEOUT}
+	var err error
+	for _, upstream := range s.Upstreams {
+		var r *dns.Msg
+		if r, _, err = client.Exchange(request, upstream); err == nil {
+			return r, nil
+		}
+		log.Printf("WARN: upstream %s: %s", upstream, err)
+	}
+	return nil, err
+}
+
+// inZone returns whether name is served from the caches rather than
+// forwarded: names in the domain, and reverse lookups in our subnets.
+func (s *NameServer) inZone(name string) bool {
+	return dns.IsSubDomain(s.domain, name) || (isReverse(name) && s.reverseZone(name) != "")
+}
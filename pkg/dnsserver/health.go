@@ -0,0 +1,47 @@
+package dnsserver
+
+import "github.com/foreflight/aws-name-server/pkg/cache"
+
+// UnhealthyAnswer is how records marked unhealthy, e.g. instances failing
+// their EC2 status checks, are answered when the name has healthy ones too.
+// When every record is unhealthy they are all answered as usual, rather
+// than leaving the name with none.
+type UnhealthyAnswer string
+
+const (
+	// EXCLUDE_UNHEALTHY leaves unhealthy records out.
+	EXCLUDE_UNHEALTHY UnhealthyAnswer = "exclude"
+	// DEPRIORITIZE_UNHEALTHY answers with them after the healthy ones, and
+	// gives their SRV records a lower priority.
+	DEPRIORITIZE_UNHEALTHY UnhealthyAnswer = "deprioritize"
+)
+
+// byHealth orders or filters records as s.Unhealthy says. records is
+// returned as is if they are all healthy, or all unhealthy. Excluding runs
+// before weights are applied, and deprioritizing after they shuffle.
+func (s *NameServer) byHealth(records []*cache.Record) []*cache.Record {
+	healthy := 0
+	for _, record := range records {
+		if !record.Unhealthy {
+			healthy++
+		}
+	}
+	if healthy == len(records) || healthy == 0 {
+		return records
+	}
+
+	results := make([]*cache.Record, 0, len(records))
+	for _, record := range records {
+		if !record.Unhealthy {
+			results = append(results, record)
+		}
+	}
+	if s.Unhealthy == DEPRIORITIZE_UNHEALTHY {
+		for _, record := range records {
+			if record.Unhealthy {
+				results = append(results, record)
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,53 @@
+package dnsserver
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ImportZone reads the RFC 1035 zone file at path, such as one kept by
+// hand for BIND, and adds its records to static, to be served alongside
+// the discovered ones. Relative names are in domain. The SOA and the
+// domain's NS records are left out, as the server has its own, and so are
+// records outside the domain, with a warning. $INCLUDE isn't allowed, so a
+// zone file can't have the server read others, and neither are wildcards,
+// as StaticRecords are only served by their exact name.
+func ImportZone(path string, domain string, static map[string][]dns.RR) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	domain = dns.Fqdn(strings.ToLower(domain))
+	parser := dns.NewZoneParser(file, domain, path)
+	imported, skipped := 0, 0
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		header := rr.Header()
+		header.Name = strings.ToLower(header.Name)
+		if header.Rrtype == dns.TypeSOA || (header.Rrtype == dns.TypeNS && header.Name == domain) {
+			continue
+		}
+		if !dns.IsSubDomain(domain, header.Name) {
+			skipped++
+			continue
+		}
+		if strings.HasPrefix(header.Name, "*.") {
+			return fmt.Errorf("%s: wildcard %s isn't supported", path, header.Name)
+		}
+		static[header.Name] = append(static[header.Name], rr)
+		imported++
+	}
+	if err := parser.Err(); err != nil {
+		return err
+	}
+	if skipped > 0 {
+		log.Printf("WARN: %s: skipped %d records outside %s", path, skipped, domain)
+	}
+	log.Printf("Imported %d records from %s", imported, path)
+	return nil
+}
@@ -0,0 +1,36 @@
+package dnsserver
+
+import "log"
+
+// Listener is an address to serve DNS on, over net (udp or tcp).
+type Listener struct {
+	Address string
+	Net     string
+}
+
+// Serve serves the handlers registered by Handle on each of listeners until
+// Shutdown. A listener that fails, e.g. because its address can't be bound,
+// is logged and the others carry on, so a TCP port in use doesn't stop UDP
+// being served. It exits if every listener fails.
+func (s *NameServer) Serve(listeners []Listener) {
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener Listener) {
+			err := s.ListenAndServe(listener.Address, listener.Net)
+			if err != nil {
+				log.Printf("ERROR: serving DNS on %s/%s: %s", listener.Address, listener.Net, err)
+			}
+			errs <- err
+		}(listener)
+	}
+
+	failed := 0
+	for range listeners {
+		if err := <-errs; err != nil {
+			failed++
+		}
+	}
+	if failed > 0 && failed == len(listeners) {
+		log.Fatalf("FATAL: can't serve DNS on any listener")
+	}
+}
@@ -0,0 +1,140 @@
+package dnsserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/miekg/dns"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+	_ "github.com/foreflight/aws-name-server/pkg/providers"
+)
+
+// DOMAIN is the domain the tests serve.
+const DOMAIN = "aws.example.com"
+
+// LAUNCHED is when the first of the test instances was launched.
+var LAUNCHED = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// INSTANCES are the running instances in each region, one account each.
+var INSTANCES = map[string][]ec2types.Instance{
+	"us-east-1": {
+		instance("i-web1", "10.0.0.1", 0, "Role", "web"),
+		instance("i-web3", "10.0.0.3", 2, "Role", "web"),
+		instance("i-broker2", "10.0.0.12", 0, "Name", "broker", "dns:index", "2"),
+	},
+	"us-west-2": {
+		instance("i-web2", "10.1.0.2", 1, "Role", "web"),
+		instance("i-broker1", "10.1.0.11", 0, "Name", "broker", "dns:index", "1"),
+		instance("i-broker", "10.1.0.10", 0, "Name", "broker"),
+	},
+}
+
+// fakeEC2 serves the INSTANCES of its region, in place of EC2.
+type fakeEC2 struct {
+	cache.EC2API
+	region string
+}
+
+func (client fakeEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []ec2types.Reservation{{Instances: INSTANCES[client.region]}},
+	}, nil
+}
+
+func (client fakeEC2) DescribeSubnets(ctx context.Context, params *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	return &ec2.DescribeSubnetsOutput{}, nil
+}
+
+// instance is a running instance launched hours after LAUNCHED, with tags
+// given as alternating keys and values.
+func instance(id string, ip string, hours int, tags ...string) ec2types.Instance {
+	i := ec2types.Instance{
+		InstanceId:       aws.String(id),
+		PrivateIpAddress: aws.String(ip),
+		LaunchTime:       aws.Time(LAUNCHED.Add(time.Duration(hours) * time.Hour)),
+		State:            &ec2types.InstanceState{Name: ec2types.InstanceStateNameRunning},
+	}
+	for n := 0; n+1 < len(tags); n += 2 {
+		i.Tags = append(i.Tags, ec2types.Tag{Key: aws.String(tags[n]), Value: aws.String(tags[n+1])})
+	}
+	return i
+}
+
+func TestMain(m *testing.M) {
+	cache.DEFAULT_CLIENTS.EC2 = func(cfg aws.Config) cache.EC2API { return fakeEC2{region: cfg.Region} }
+	os.Exit(m.Run())
+}
+
+// newTestServer serves the INSTANCES of a prod and a staging account.
+func newTestServer(t *testing.T) *NameServer {
+	accounts := []*cache.AWSAccount{
+		{NickName: "prod", Regions: []string{"us-east-1"}, Services: []string{"ec2"}},
+		{NickName: "staging", Regions: []string{"us-west-2"}, Services: []string{"ec2"}},
+	}
+	caches, _, err := cache.NewCaches(accounts, DOMAIN, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(caches.Stop)
+	for _, c := range caches.All() {
+		if c.Staleness() > 0 {
+			t.Fatalf("%s account in %s failed its first refresh", c.Nickname(), c.Account().Region)
+		}
+	}
+	return NewNameServer(DOMAIN, "ns."+DOMAIN, caches)
+}
+
+// query asks server for the A records of name and returns their
+// addresses, sorted.
+func query(t *testing.T, server *NameServer, name string) []string {
+	request := new(dns.Msg).SetQuestion(dns.Fqdn(name), dns.TypeA)
+	response := server.Reply(request, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	var answers []string
+	for _, rr := range response.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			answers = append(answers, a.A.String())
+		}
+	}
+	sort.Strings(answers)
+	return answers
+}
+
+func expect(t *testing.T, name string, got []string, want []string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s: got %v, want %v", name, got, want)
+	}
+}
+
+func TestLookupMergesCaches(t *testing.T) {
+	server := newTestServer(t)
+
+	expect(t, "every account", query(t, server, "web.role."+DOMAIN), []string{"10.0.0.1", "10.0.0.3", "10.1.0.2"})
+	expect(t, "prod", query(t, server, "web.role.prod."+DOMAIN), []string{"10.0.0.1", "10.0.0.3"})
+	expect(t, "staging", query(t, server, "web.role.staging."+DOMAIN), []string{"10.1.0.2"})
+}
+
+func TestLookupNth(t *testing.T) {
+	server := newTestServer(t)
+
+	// numbered by launch order across the accounts
+	expect(t, "0", query(t, server, "0.web.role."+DOMAIN), []string{"10.0.0.1"})
+	expect(t, "1", query(t, server, "1.web.role."+DOMAIN), []string{"10.1.0.2"})
+	expect(t, "2", query(t, server, "2.web.role."+DOMAIN), []string{"10.0.0.3"})
+	expect(t, "past the end", query(t, server, "3.web.role."+DOMAIN), nil)
+	expect(t, "in prod", query(t, server, "1.web.role.prod."+DOMAIN), []string{"10.0.0.3"})
+
+	// once any are tagged dns:index, only the tagged ones are numbered
+	expect(t, "index 1", query(t, server, "1.broker."+DOMAIN), []string{"10.1.0.11"})
+	expect(t, "index 2", query(t, server, "2.broker."+DOMAIN), []string{"10.0.0.12"})
+	expect(t, "untagged", query(t, server, "0.broker."+DOMAIN), nil)
+}
@@ -0,0 +1,78 @@
+package dnsserver
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aws_name_server_queries_total",
+		Help: "DNS queries answered, by query type and response code.",
+	}, []string{"qtype", "rcode"})
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aws_name_server_query_duration_seconds",
+		Help:    "Time taken to answer DNS queries, by query type.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	}, []string{"qtype"})
+)
+
+func init() {
+	prometheus.MustRegister(queriesTotal, queryDuration)
+}
+
+// instrument wraps a DNS handler to count its queries, time its answers,
+// trace them and send them to the server's Tap.
+func (s *NameServer) instrument(handler dns.HandlerFunc) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, request *dns.Msg) {
+		recorder := &rcodeRecorder{ResponseWriter: s.Tap.writer(w, request), rcode: -1}
+		start := time.Now()
+		span := startQuerySpan(request)
+		handler(recorder, request)
+		endQuerySpan(span, recorder.rcode)
+		observeQuery(request, recorder.rcode, start)
+	}
+}
+
+// observeQuery records the metrics for a query answered with rcode, or
+// -1 if no answer was sent.
+func observeQuery(request *dns.Msg, rcode int, start time.Time) {
+	qtype := "NONE"
+	if len(request.Question) > 0 {
+		qtype = dns.TypeToString[request.Question[0].Qtype]
+	}
+	if qtype == "" {
+		qtype = "OTHER"
+	}
+	rcodeName := "NONE"
+	if rcode >= 0 {
+		rcodeName = dns.RcodeToString[rcode]
+	}
+	queriesTotal.WithLabelValues(qtype, rcodeName).Inc()
+	queryDuration.WithLabelValues(qtype).Observe(time.Since(start).Seconds())
+}
+
+// rcodeRecorder remembers the response code of the first message written,
+// zone transfers writing several.
+type rcodeRecorder struct {
+	dns.ResponseWriter
+	rcode int
+}
+
+func (recorder *rcodeRecorder) WriteMsg(msg *dns.Msg) error {
+	if recorder.rcode == -1 {
+		recorder.rcode = msg.Rcode
+	}
+	return recorder.ResponseWriter.WriteMsg(msg)
+}
+
+// Write records the response code of a packed message, as answers from
+// the ResponseCache are written.
+func (recorder *rcodeRecorder) Write(packed []byte) (int, error) {
+	if recorder.rcode == -1 && len(packed) >= 4 {
+		recorder.rcode = int(packed[3] & 0xF)
+	}
+	return recorder.ResponseWriter.Write(packed)
+}
@@ -0,0 +1,925 @@
+// Package dnsserver answers DNS queries for a domain from the records in a
+// cache.CacheSet: <name>.<domain>, <role>.role.<domain> and the rest, along
+// with reverse lookups, zone transfers, DNSSEC and DNS-over-HTTPS.
+package dnsserver
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+	"github.com/miekg/dns"
+)
+
+// EDNS_BUFFER_SIZE is the UDP payload size we advertise to EDNS clients.
+// 1232 bytes avoids IP fragmentation on any sane path (DNS flag day 2020).
+const EDNS_BUFFER_SIZE = 1232
+
+// NameServer answers queries for a domain from a CacheSet. The exported
+// fields configure it, and must be set before it starts serving.
+type NameServer struct {
+	domain   string
+	hostname string
+	// dotDomain is "." + domain, so queries don't build it each time.
+	dotDomain string
+	caches    *cache.CacheSet
+	journal   *Journal
+	misses    *negativeCache
+
+	zoneMutex sync.Mutex
+
+	// Signer signs answers with DNSSEC, disabled if nil.
+	Signer *Signer
+
+	// TSIGSecrets are the keys clients may sign queries with, see
+	// TSIGSecrets, and RequireTSIG refuses any query not signed with one.
+	TSIGSecrets map[string]string
+	RequireTSIG bool
+
+	// AllowedSubnets refuses queries from clients outside them, unless empty.
+	AllowedSubnets []*net.IPNet
+	// ReverseZones are subnets the server answers reverse lookups in, as
+	// well as the subnets of the accounts' VPCs, see reverseZone.
+	ReverseZones []*net.IPNet
+	// Views choose between private and public answers by client subnet.
+	Views []*View
+	// DefaultView is used for clients that don't match any of the Views,
+	// DEFAULT_VIEW if nil.
+	DefaultView *View
+	// Upstreams are the resolvers queries outside the domain are
+	// forwarded to, which are refused if empty.
+	Upstreams []string
+	// NameServers are the hostnames in the domain's NS records, the
+	// hostname alone if empty, see ParseNameServers.
+	NameServers []string
+	// Wildcards maps a subdomain to the Name whose instances answer for
+	// everything under it.
+	Wildcards map[string]string
+	// CNAMEs are names in the domain answered with a CNAME, for resources
+	// that aren't discovered, see ParseCNAMEs.
+	CNAMEs map[string]*cache.Record
+	// StaticRecords are served alongside the discovered records, by fully
+	// qualified name, see ParseStaticRecords and ImportZone.
+	StaticRecords map[string][]dns.RR
+	// FlattenCNAMEs answers with the A records of CNAME targets instead.
+	FlattenCNAMEs bool
+	// VPCs gives VPC ids nicknames, for vpc-<nickname> lookups.
+	VPCs map[string]string
+
+	// QueryLog decides which queries are logged.
+	QueryLog *QueryLog
+	// Tap sends dnstap frames for each query, disabled if nil.
+	Tap *Tap
+
+	// Unhealthy is how records failing a health check are answered,
+	// EXCLUDE_UNHEALTHY if empty.
+	Unhealthy UnhealthyAnswer
+
+	// Stopped answers for names whose instances are all stopped, when
+	// they are discovered, see StoppedAnswer. Disabled if nil.
+	Stopped *StoppedAnswer
+
+	// NegativeTTL is how long resolvers may cache that a name doesn't
+	// exist, the SOA's MINIMUM, or NEGATIVE_TTL if 0.
+	NegativeTTL time.Duration
+
+	// SOASettings are the SOA's fields and serial strategy, see SOASettings.
+	SOASettings SOASettings
+
+	// ResponseCache answers repeated queries with the same packed
+	// response until the caches refresh, disabled if nil.
+	ResponseCache *ResponseCache
+
+	// UDPWorkers is how many UDP sockets ListenAndServe opens on the same
+	// port with SO_REUSEPORT, for the kernel to spread queries across, as
+	// a single socket tops out well below what the server can answer.
+	UDPWorkers int
+
+	// Secondary serves the zone of a primary aws-name-server instead of
+	// the caches, see Secondary. Disabled if nil.
+	Secondary *Secondary
+
+	// NotifyAddresses are the secondaries sent a NOTIFY whenever the zone
+	// changes, see ParseUpstreams.
+	NotifyAddresses []string
+
+	// Route53 and Etcd are pushed the zone whenever it changes, if set,
+	// see PushRoute53 and PushEtcd.
+	Route53 *Route53Sync
+	Etcd    *EtcdSync
+
+	// the listeners, for Shutdown
+	serversMutex sync.Mutex
+	dnsServers   []*dns.Server
+	httpServers  []*http.Server
+}
+
+type response struct {
+	*dns.Msg
+}
+
+// NewNameServer creates a NameServer for domain, answering with the records
+// in caches and naming hostname as the domain's name server.
+func NewNameServer(domain string, hostname string, caches *cache.CacheSet) *NameServer {
+
+	// names are matched lower cased, see lowerCase
+	domain, hostname = strings.ToLower(domain), strings.ToLower(hostname)
+	if !strings.HasSuffix(domain, ".") {
+		domain += "."
+	}
+	if !strings.HasSuffix(hostname, ".") {
+		hostname += "."
+	}
+
+	server := &NameServer{
+		domain:    domain,
+		hostname:  hostname,
+		dotDomain: "." + domain,
+		caches:    caches,
+		journal:   NewJournal(),
+		misses:    newNegativeCache(),
+		QueryLog:  &QueryLog{Sample: 1},
+	}
+
+	server.updateZone()
+	caches.Subscribe(server.updateZone)
+	caches.Subscribe(func() { server.ResponseCache.Clear() })
+	caches.Subscribe(server.misses.clear)
+
+	return server
+}
+
+// Domain is the fully qualified domain the server answers for.
+func (s *NameServer) Domain() string {
+	return s.domain
+}
+
+// Hostname is the fully qualified name of the server, as in its NS record.
+func (s *NameServer) Hostname() string {
+	return s.hostname
+}
+
+// Handle registers the server with the dns package's default mux, for the
+// domain and the reverse lookup zones, and everything else if there are
+// Upstreams to forward to.
+func (s *NameServer) Handle() {
+	dns.HandleFunc(s.domain, s.instrument(s.handleRequest))
+	dns.HandleFunc("in-addr.arpa.", s.instrument(s.handleRequest))
+	dns.HandleFunc("ip6.arpa.", s.instrument(s.handleRequest))
+	if len(s.Upstreams) > 0 {
+		dns.HandleFunc(".", s.instrument(s.handleForward))
+	}
+}
+
+// ServeDNS answers request for the domain or a reverse lookup, making the
+// NameServer a dns.Handler for servers that embed it, such as the CoreDNS
+// aws_tags plugin. Unlike the handlers registered by the command it isn't
+// instrumented, as the embedding server has its own metrics and tracing.
+func (s *NameServer) ServeDNS(w dns.ResponseWriter, request *dns.Msg) {
+	s.handleRequest(w, request)
+}
+
+// CAPABILITIES explains what to do when binding to port 53 isn't permitted.
+const CAPABILITIES = `FATAL
+
+You need to give this program permission to bind to port 53.
+
+Using capabilities (recommended):
+ $ sudo setcap cap_net_bind_service=+ep "$(which aws-name-server)"
+
+Just run it as root (not recommended):
+ $ sudo aws-name-server
+`
+
+// ListenAndServe serves the handlers registered by Handle on address, over
+// net (udp or tcp), until Shutdown. UDP is served on UDPWorkers sockets. It
+// returns the error of the first socket that fails, e.g. because address
+// can't be bound.
+func (s *NameServer) ListenAndServe(address string, net string) error {
+	workers := 1
+	if net == "udp" && s.UDPWorkers > 1 {
+		workers = s.UDPWorkers
+	}
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func() { errs <- s.listenAndServe(address, net, workers > 1) }()
+	}
+	return <-errs
+}
+
+// listenAndServe serves one socket on address, which may share the address
+// with others if reusePort is set.
+func (s *NameServer) listenAndServe(address string, net string, reusePort bool) error {
+	server := &dns.Server{Addr: address, Net: net, TsigSecret: s.TSIGSecrets, ReusePort: reusePort}
+	s.serving(server)
+	err := server.ListenAndServe()
+	if err != nil && strings.Contains(err.Error(), "permission denied") {
+		log.Printf(CAPABILITIES)
+	}
+	return err
+}
+
+func (s *NameServer) handleRequest(w dns.ResponseWriter, request *dns.Msg) {
+	if !s.allowed(w.RemoteAddr()) {
+		log.Printf("WARN: refusing %v (id=%v): not in an allowed subnet", w.RemoteAddr(), request.Id)
+		w.WriteMsg(new(dns.Msg).SetRcode(request, dns.RcodeRefused))
+		return
+	}
+
+	if rcode := s.authorize(request, w.TsigStatus()); rcode != dns.RcodeSuccess {
+		log.Printf("WARN: refusing %v (id=%v): %s", w.RemoteAddr(), request.Id, dns.RcodeToString[rcode])
+		w.WriteMsg(new(dns.Msg).SetRcode(request, rcode))
+		return
+	}
+
+	if request.Opcode == dns.OpcodeNotify {
+		s.notified(w, request)
+		return
+	}
+
+	if s.Secondary != nil && !s.Secondary.ready() {
+		w.WriteMsg(new(dns.Msg).SetRcode(request, dns.RcodeServerFailure))
+		return
+	}
+
+	if len(request.Question) == 1 && (request.Question[0].Qtype == dns.TypeAXFR || request.Question[0].Qtype == dns.TypeIXFR) {
+		s.transfer(w, request)
+		return
+	}
+
+	// reverse lookups for addresses outside our subnets aren't ours to answer
+	if len(request.Question) == 1 && len(s.Upstreams) > 0 && isReverse(request.Question[0].Name) && s.reverseZone(request.Question[0].Name) == "" {
+		s.handleForward(w, request)
+		return
+	}
+
+	_, udp := w.RemoteAddr().(*net.UDPAddr)
+	size := dns.MaxMsgSize
+	if udp {
+		size = dns.MinMsgSize
+		if opt := request.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+	}
+
+	key, cacheable := s.responseKey(request, w.RemoteAddr())
+	var generation uint64
+	if cacheable {
+		if packed := s.ResponseCache.get(key, request.Id); packed != nil && len(packed) <= size {
+			s.QueryLog.Log(request.Question[0], w.RemoteAddr(), request.Id)
+			w.Write(packed)
+			return
+		}
+		generation = s.ResponseCache.Generation()
+	}
+
+	r := s.Reply(request, w.RemoteAddr())
+	if udp {
+		// sets the TC bit if anything had to be dropped, so the client
+		// retries over TCP, leaving room for the MAC added below
+		if t := request.IsTsig(); t != nil {
+			r.Truncate(size - tsigLen(t))
+		} else {
+			r.Truncate(size)
+		}
+	}
+	if t := request.IsTsig(); t != nil {
+		// the dns.Server adds the MAC when writing
+		r.SetTsig(t.Hdr.Name, t.Algorithm, t.Fudge, time.Now().Unix())
+	}
+	if cacheable && !r.Truncated {
+		if packed, err := r.Pack(); err == nil {
+			s.ResponseCache.put(key, packed, generation)
+			w.Write(packed)
+			return
+		}
+	}
+	w.WriteMsg(r)
+}
+
+// Reply builds the response to request, which came from remote.
+func (s *NameServer) Reply(request *dns.Msg, remote net.Addr) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetReply(request)
+	r.Authoritative = true
+
+	opt := request.IsEdns0()
+	if opt != nil && opt.Version() != 0 {
+		r.SetEdns0(EDNS_BUFFER_SIZE, false)
+		r.Rcode = dns.RcodeBadVers
+		return r
+	}
+
+	do := s.Signer != nil && opt != nil && opt.Do()
+	client := s.client(request, remote)
+	for _, question := range request.Question {
+		s.QueryLog.Log(question, remote, request.Id)
+		msg := lowerCase(question)
+		answered, authority, extra := len(r.Answer), len(r.Ns), len(r.Extra)
+
+		answers := s.Answer(msg, client)
+		if len(answers) > 0 {
+			r.Answer = append(r.Answer, answers...)
+			if msg.Qtype == dns.TypeNS {
+				r.Extra = append(r.Extra, s.glue(client)...)
+			}
+		} else if isReverse(msg.Name) {
+			s.answerReverseMiss(r, msg)
+		} else if stopped := s.stopped(msg); len(stopped) > 0 {
+			s.answerStopped(r, msg, stopped, do)
+		} else {
+			r.Ns = append(r.Ns, s.SOA(msg))
+			if do {
+				r.Ns = append(r.Ns, s.Signer.NSEC(msg.Name, s.negativeTTL(), s.types(msg, client)))
+			}
+		}
+		withCase(r.Answer[answered:], msg.Name, question.Name)
+		withCase(r.Ns[authority:], msg.Name, question.Name)
+		withCase(r.Extra[extra:], msg.Name, question.Name)
+	}
+
+	if opt != nil {
+		if do {
+			r.Answer = s.Signer.Sign(s.domain, r.Answer)
+			r.Ns = s.Signer.Sign(s.domain, r.Ns)
+		}
+		r.SetEdns0(EDNS_BUFFER_SIZE, do)
+		if subnet := client.subnetOption(); subnet != nil {
+			edns := r.IsEdns0()
+			edns.Option = append(edns.Option, subnet)
+		}
+	}
+
+	return r
+}
+
+// Answer finds the records for msg, with addresses chosen for client,
+// along with any StaticRecords.
+func (s *NameServer) Answer(msg dns.Question, client *Client) []dns.RR {
+	answers := s.answer(msg, client)
+	if s.Secondary == nil {
+		// a secondary has the primary's in the zone it transferred
+		answers = append(answers, s.static(msg)...)
+	}
+	return answers
+}
+
+// answer finds the discovered records for msg.
+func (s *NameServer) answer(msg dns.Question, client *Client) (answers []dns.RR) {
+
+	if msg.Qtype == dns.TypeNS {
+		if msg.Name == s.domain {
+			answers = s.NS()
+		}
+		return answers
+	}
+
+	if msg.Qtype == dns.TypeSOA {
+		if msg.Name == s.domain {
+			answers = append(answers, s.SOA(msg))
+		}
+		return answers
+	}
+
+	if msg.Qtype == dns.TypeDNSKEY {
+		if msg.Name == s.domain && s.Signer != nil {
+			answers = append(answers, s.Signer.DNSKEY()...)
+		}
+		return answers
+	}
+
+	if s.Secondary != nil {
+		return s.Secondary.answer(msg)
+	}
+
+	if isReverse(msg.Name) {
+		if msg.Qtype == dns.TypePTR {
+			answers = s.PTR(msg)
+		}
+		return answers
+	}
+
+	if msg.Qtype == dns.TypeSRV {
+		return s.SRV(msg)
+	}
+
+	// RFC 8482: rather than the whole RRset, answer ANY with a single HINFO
+	if msg.Qtype == dns.TypeANY {
+		if msg.Name == s.domain || len(s.StaticRecords[msg.Name]) > 0 || len(s.Lookup(msg)) > 0 {
+			answers = append(answers, &dns.HINFO{
+				Hdr: dns.RR_Header{Name: msg.Name, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: uint32(cache.TTL / time.Second)},
+				Cpu: "RFC8482",
+			})
+		}
+		return answers
+	}
+
+	// handle public lookup, e.g. pub.web.internal
+	view := client.View
+	lookup := msg
+	if strings.HasPrefix(msg.Name, "pub.") && msg.Name[len("pub."):] != s.domain {
+		lookup.Name = msg.Name[len("pub."):]
+		view = PUBLIC_VIEW
+	}
+
+	// unhealthy records are left out before weights drain any, so a passive
+	// record with weight 0 takes over from an unhealthy active one
+	records := s.Lookup(lookup)
+	if s.Unhealthy != DEPRIORITIZE_UNHEALTHY {
+		records = s.byHealth(records)
+	}
+	records = client.sortByTopology(sortByWeight(client.zoneLocal(records)))
+	if s.Unhealthy == DEPRIORITIZE_UNHEALTHY {
+		records = s.byHealth(records)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	answers = make([]dns.RR, 0, len(records))
+	now := time.Now()
+	for _, record := range records {
+		if record.CName != "" && len(records) > 1 {
+			// shared with another account's instances: a name with a
+			// CNAME can have no other records (RFC 1034)
+			continue
+		}
+		ttl := uint32(record.TTL(now) / time.Second)
+
+		if msg.Qtype == dns.TypeA && record.CName != "" && s.FlattenCNAMEs {
+			answers = append(answers, flatten(msg.Name, record.CName, ttl)...)
+		} else if msg.Qtype == dns.TypeA {
+			if rr := view.addressRR(msg.Name, record, ttl); rr != nil {
+				answers = append(answers, rr)
+			}
+		}
+	}
+
+	return answers
+}
+
+// Lookup finds the records for the name in msg, remembering names with
+// none so repeated queries for them skip the lookup.
+func (s *NameServer) Lookup(msg dns.Question) []*cache.Record {
+	miss, generation := s.misses.has(msg.Name)
+	if miss {
+		return nil
+	}
+	results := s.lookup(msg, false)
+	if len(results) == 0 {
+		s.misses.add(msg.Name, generation)
+	}
+	return results
+}
+
+// lookup finds the records for the name in msg, or the stopped instances
+// if stopped is set. The name is taken apart label by label from the right,
+// by slicing rather than splitting it, as this runs for every query.
+func (s *NameServer) lookup(msg dns.Question, stopped bool) []*cache.Record {
+	name := strings.TrimSuffix(msg.Name, s.dotDomain)
+
+	nth := 0
+	indexed := false
+	tag := cache.LOOKUP_NAME
+	caches := s.caches.All()
+
+	// handle account lookup, e.g. web.prod.internal
+	scoped := false
+	if rest, label, ok := lastLabel(name); ok {
+		if accountCaches := s.accountCaches(label); len(accountCaches) > 0 {
+			caches = accountCaches
+			name = rest
+			scoped = true
+		}
+	}
+
+	// handle availability zone lookup, e.g. web.us-east-1a.internal
+	zone := ""
+	if rest, label, ok := lastLabel(name); ok && AVAILABILITY_ZONE.MatchString(label) {
+		zone = label
+		name = rest
+	}
+
+	// handle vpc lookup, e.g. web.vpc-prod.internal or web.vpc-0123abcd.internal
+	vpc := ""
+	if rest, label, ok := lastLabel(name); ok {
+		if id, ok := s.vpcID(label); ok {
+			vpc = id
+			name = rest
+		}
+	}
+
+	// handle name filtering, e.g. web.role.prod.name.internal for the
+	// instances with both Role=web and Name=prod
+	named := ""
+	if rest, labels, ok := lastLabels(name, 2); ok && strings.HasSuffix(labels, ".name") {
+		if _, _, ok := subdomainLookup(rest); ok {
+			named = strings.TrimSuffix(labels, ".name")
+			name = rest
+		}
+	}
+
+	// handle role lookup, e.g. web.role.internal, and other subdomains
+	// like redis.ro.cache.internal
+	if lookup, rest, ok := subdomainLookup(name); ok {
+		tag = lookup.LookupTag
+		name = rest
+	}
+
+	hostNick := name
+
+	// handle nth lookup, e.g. 1.web.internal
+	if label, rest, ok := firstLabel(name); ok {
+		if i, err := strconv.Atoi(label); err == nil {
+			nth = i
+			indexed = true
+			hostNick = rest
+		}
+	}
+
+	var results []*cache.Record
+	if cname, ok := s.CNAMEs[hostNick]; ok && tag == cache.LOOKUP_NAME && !scoped && !stopped {
+		// a name with a CNAME can have no other records (RFC 1034)
+		results = []*cache.Record{cname}
+	} else if _, suffix, ok := firstLabel(hostNick); ok && tag == cache.LOOKUP_NAME {
+		// handle wildcard lookup, e.g. anything.api.internal
+		results = lookupWildcard(caches, suffix, s.Wildcards, stopped)
+	} else if ok {
+		// handle names with several labels, e.g. b-1.kafka.msk.internal
+		results = lookupKey(caches, tag, hostNick, stopped)
+	} else if hostNick == "" {
+		log.Printf("ERROR: badly formed: %s", msg.Name)
+		return nil
+	} else {
+		results = lookupKey(caches, tag, hostNick, stopped)
+	}
+
+	if zone != "" {
+		results = inAvailabilityZone(results, zone)
+	}
+	if vpc != "" {
+		results = inVPC(results, vpc)
+	}
+	if named != "" {
+		results = inBoth(results, lookupKey(caches, cache.LOOKUP_NAME, named, stopped))
+	}
+
+	if indexed {
+		if tagged, ok := withIndex(results, nth); ok {
+			results = tagged
+		} else if nth >= len(results) {
+			results = nil
+		} else {
+			// the caches' order changes between refreshes, and they are
+			// merged in the order they happen to be in
+			sorted := append([]*cache.Record(nil), results...)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+			results = sorted[nth : nth+1]
+		}
+	}
+
+	return results
+}
+
+// withIndex finds the records tagged dns:index=nth. ok is false if none of
+// results are tagged, and they are numbered by launch order instead. Once
+// any are tagged, nth only finds those tagged with it, so an untagged
+// instance doesn't take the place of a missing one.
+func withIndex(results []*cache.Record, nth int) (tagged []*cache.Record, ok bool) {
+	for _, record := range results {
+		if record.Index == nil {
+			continue
+		}
+		ok = true
+		if int(*record.Index) == nth {
+			tagged = append(tagged, record)
+		}
+	}
+	return tagged, ok
+}
+
+// subdomainLookup finds the subdomain name is under, e.g. web.role or
+// redis.ro.cache, preferring the longest match, and the name within it.
+func subdomainLookup(name string) (lookup *cache.TagLookup, rest string, ok bool) {
+	for n := 2; n > 0; n-- {
+		if rest, subdomain, ok := lastLabels(name, n); ok {
+			if lookup, ok := cache.SubdomainLookup(subdomain); ok {
+				return lookup, rest, true
+			}
+		}
+	}
+	return nil, name, false
+}
+
+// inBoth returns the records that are also in others, as an instance is
+// the same Record under each of its tags.
+func inBoth(records []*cache.Record, others []*cache.Record) []*cache.Record {
+	found := make(map[*cache.Record]bool, len(others))
+	for _, record := range others {
+		found[record] = true
+	}
+	var results []*cache.Record
+	for _, record := range records {
+		if found[record] {
+			results = append(results, record)
+		}
+	}
+	return results
+}
+
+// firstLabel splits the first label off name, e.g. 1.web => 1, web. ok is
+// false if name is a single label.
+func firstLabel(name string) (label string, rest string, ok bool) {
+	i := strings.IndexByte(name, '.')
+	if i < 0 {
+		return name, "", false
+	}
+	return name[:i], name[i+1:], true
+}
+
+// lastLabel splits the last label off name, e.g. web.prod => web, prod. ok
+// is false if name is a single label, which is never taken.
+func lastLabel(name string) (rest string, label string, ok bool) {
+	return lastLabels(name, 1)
+}
+
+// lastLabels splits the last n labels off name, e.g. redis.ro.cache, 2 =>
+// redis, ro.cache. ok is false unless at least one label is left over.
+func lastLabels(name string, n int) (rest string, labels string, ok bool) {
+	i := len(name)
+	for ; n > 0; n-- {
+		if i = strings.LastIndexByte(name[:i], '.'); i < 0 {
+			return name, "", false
+		}
+	}
+	return name[:i], name[i+1:], true
+}
+
+// AVAILABILITY_ZONE matches availability zone names, including local zones
+// such as us-west-2-lax-1a.
+var AVAILABILITY_ZONE = regexp.MustCompile("^[a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-[0-9]+(-[a-z]+-[0-9]+)?[a-z]$")
+
+// inAvailabilityZone filters records down to those in an availability zone.
+func inAvailabilityZone(records []*cache.Record, zone string) []*cache.Record {
+	var results []*cache.Record
+	for _, record := range records {
+		if record.AvailabilityZone == zone {
+			results = append(results, record)
+		}
+	}
+	return results
+}
+
+// VPC_ID matches VPC ids such as vpc-0123456789abcdef0.
+var VPC_ID = regexp.MustCompile("^vpc-[0-9a-f]{8,17}$")
+
+// vpcID returns the VPC id a label refers to, either vpc-<nickname> using the
+// VPCs in the config file or the VPC id itself.
+func (s *NameServer) vpcID(label string) (string, bool) {
+	if !strings.HasPrefix(label, "vpc-") {
+		return "", false
+	}
+	for id, nickname := range s.VPCs {
+		if "vpc-"+cache.Sanitize(nickname) == label {
+			return id, true
+		}
+	}
+	if VPC_ID.MatchString(label) {
+		return label, true
+	}
+	return "", false
+}
+
+// inVPC filters records down to those in a VPC.
+func inVPC(records []*cache.Record, vpc string) []*cache.Record {
+	var results []*cache.Record
+	for _, record := range records {
+		if record.VpcID == vpc {
+			results = append(results, record)
+		}
+	}
+	return results
+}
+
+// accountCaches returns the caches for the account whose nickname is label.
+func (s *NameServer) accountCaches(label string) []*cache.Cache {
+	var caches []*cache.Cache
+	for _, cache := range s.caches.All() {
+		if cache.Nickname() == label {
+			caches = append(caches, cache)
+		}
+	}
+	return caches
+}
+
+// lookupKey merges the records for a tag and value across caches, or the
+// stopped instances if stopped is set. When only one cache has any, its
+// slice is returned as is, so the result must not be modified.
+func lookupKey(caches []*cache.Cache, tag cache.LookupTag, value string, stopped bool) []*cache.Record {
+	var results []*cache.Record
+	for _, c := range caches {
+		var records []*cache.Record
+		if stopped {
+			records = c.LookupStopped(tag, value)
+		} else {
+			records = c.Lookup(tag, value)
+		}
+		if len(results) == 0 {
+			results = records
+		} else if len(records) > 0 {
+			// copy rather than append to the first cache's slice
+			results = append(results[:len(results):len(results)], records...)
+		}
+	}
+	return results
+}
+
+// lookupWildcard finds the closest wildcard covering the labels in suffix,
+// either from an instance tagged Name=*.<suffix> or the config file.
+func lookupWildcard(caches []*cache.Cache, suffix string, wildcards map[string]string, stopped bool) []*cache.Record {
+	for name, more := suffix, true; more; {
+		if results := lookupKey(caches, cache.LOOKUP_WILDCARD, name, stopped); len(results) > 0 {
+			return results
+		}
+		if target, ok := wildcards[name]; ok {
+			return lookupKey(caches, cache.LOOKUP_NAME, target, stopped)
+		}
+		_, name, more = firstLabel(name)
+	}
+	return nil
+}
+
+// PTR maps a reverse lookup for a cached private IP back to <name>.<domain>.
+func (s *NameServer) PTR(msg dns.Question) (answers []dns.RR) {
+	ip := reverseIP(msg.Name)
+	if ip == nil {
+		log.Printf("ERROR: badly formed: %s", msg.Name)
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for _, cache := range s.caches.All() {
+		for _, record := range cache.ReverseLookup(ip) {
+			target := record.Name + "." + s.domain
+			if seen[target] {
+				continue
+			}
+			seen[target] = true
+			answers = append(answers, &dns.PTR{
+				Hdr: dns.RR_Header{Name: msg.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: uint32(record.TTL(time.Now()) / time.Second)},
+				Ptr: target,
+			})
+		}
+	}
+	return answers
+}
+
+// SRV answers _<service>._<proto>.<name>.<domain> with the ports from
+// dns:srv:<service> or Port tags, targeting each instance by its id.
+func (s *NameServer) SRV(msg dns.Question) (answers []dns.RR) {
+	labels := strings.SplitN(msg.Name, ".", 3)
+	if len(labels) != 3 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+		return nil
+	}
+	service := strings.TrimPrefix(labels[0], "_")
+
+	host := msg
+	host.Name = labels[2]
+	for _, record := range s.byHealth(s.Lookup(host)) {
+		port := record.ServicePort(service)
+
+		if port == 0 || record.InstanceID == "" {
+			continue
+		}
+		var priority uint16
+		if record.Unhealthy && s.Unhealthy == DEPRIORITIZE_UNHEALTHY {
+			priority = 1
+		}
+		answers = append(answers, &dns.SRV{
+			Hdr:      dns.RR_Header{Name: msg.Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: uint32(record.TTL(time.Now()) / time.Second)},
+			Priority: priority,
+			Weight:   uint16(weight(record)),
+			Port:     port,
+			Target:   record.InstanceID + "." + s.domain,
+		})
+	}
+	return answers
+}
+
+// reverseZone returns the reverse zone name is in, that of the subnet
+// containing its address, if it is in ReverseZones or an account's subnets,
+// or has records. It returns "" if the server doesn't answer for name.
+func (s *NameServer) reverseZone(name string) string {
+	ip := reverseIP(strings.ToLower(name))
+	if ip == nil {
+		return ""
+	}
+	for _, subnet := range s.ReverseZones {
+		if subnet.Contains(ip) {
+			return reverseZoneName(subnet)
+		}
+	}
+	for _, c := range s.caches.All() {
+		if subnet := c.SubnetOf(ip); subnet != nil {
+			return reverseZoneName(subnet)
+		}
+	}
+	if s.hasReverse(ip) {
+		return strings.ToLower(name)
+	}
+	return ""
+}
+
+// reverseZoneName is the in-addr.arpa or ip6.arpa name of subnet, widened
+// to the octet or nibble boundary at or above its prefix length.
+func reverseZoneName(subnet *net.IPNet) string {
+	name, err := dns.ReverseAddr(subnet.IP.String())
+	if err != nil {
+		return ""
+	}
+	ones, bits := subnet.Mask.Size()
+	labels := dns.SplitDomainName(name)
+	drop := (bits - ones + 3) / 4
+	if subnet.IP.To4() != nil {
+		drop = (bits - ones + 7) / 8
+	}
+	return dns.Fqdn(strings.Join(labels[drop:], "."))
+}
+
+// hasReverse returns whether any instance has the private IP ip.
+func (s *NameServer) hasReverse(ip net.IP) bool {
+	for _, c := range s.caches.All() {
+		if len(c.ReverseLookup(ip)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// answerReverseMiss fills in r's answer to the reverse lookup msg, which
+// found no records: NODATA if its address has any but of another type,
+// NXDOMAIN if not, both with the reverse zone's SOA, and REFUSED if the
+// address isn't in a reverse zone the server answers for.
+func (s *NameServer) answerReverseMiss(r *dns.Msg, msg dns.Question) {
+	zone := s.reverseZone(msg.Name)
+	if zone == "" {
+		r.Rcode = dns.RcodeRefused
+		r.Authoritative = false
+		return
+	}
+	if !s.hasReverse(reverseIP(msg.Name)) {
+		r.Rcode = dns.RcodeNameError
+	}
+	soa := s.SOA(msg).(*dns.SOA)
+	soa.Hdr.Name = zone
+	r.Ns = append(r.Ns, soa)
+}
+
+func isReverse(name string) bool {
+	return strings.HasSuffix(name, ".in-addr.arpa.") || strings.HasSuffix(name, ".ip6.arpa.")
+}
+
+// reverseIP parses an in-addr.arpa or ip6.arpa name back into an IP address.
+// It returns nil if the name does not describe a complete address.
+func reverseIP(name string) net.IP {
+	if strings.HasSuffix(name, ".in-addr.arpa.") {
+		labels := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa."), ".")
+		if len(labels) != 4 {
+			return nil
+		}
+		for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+			labels[i], labels[j] = labels[j], labels[i]
+		}
+		return net.ParseIP(strings.Join(labels, ".")).To4()
+	}
+
+	if strings.HasSuffix(name, ".ip6.arpa.") {
+		nibbles := strings.Split(strings.TrimSuffix(name, ".ip6.arpa."), ".")
+		if len(nibbles) != 32 {
+			return nil
+		}
+		hex := make([]byte, 0, 39)
+		for i := len(nibbles) - 1; i >= 0; i-- {
+			if len(nibbles[i]) != 1 {
+				return nil
+			}
+			hex = append(hex, nibbles[i][0])
+			if i%4 == 0 && i > 0 {
+				hex = append(hex, ':')
+			}
+		}
+		return net.ParseIP(string(hex))
+	}
+
+	return nil
+}
+
+func (s *NameServer) SOA(msg dns.Question) dns.RR {
+	return s.soa(s.journal.Serial())
+}
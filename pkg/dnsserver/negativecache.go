@@ -0,0 +1,67 @@
+package dnsserver
+
+import (
+	"sync"
+	"time"
+)
+
+// NEGATIVE_TTL is the TTL of negative answers, and the SOA's MINIMUM,
+// unless NameServer.NegativeTTL is set.
+const NEGATIVE_TTL = 60 * time.Second
+
+// NEGATIVE_CACHE_SIZE is the most names without records remembered. The
+// cache is emptied when full, as a flood of distinct names would fill it.
+const NEGATIVE_CACHE_SIZE = 10000
+
+// negativeCache remembers the names Lookup found no records for, so floods
+// of queries for names that don't exist, typos and removed hosts, skip the
+// lookup. Records only change when the caches refresh, which clears it.
+type negativeCache struct {
+	mutex  sync.RWMutex
+	misses map[string]bool
+	// generation counts the clears, so misses looked up in records that
+	// have since been refreshed aren't remembered.
+	generation uint64
+}
+
+func newNegativeCache() *negativeCache {
+	return &negativeCache{misses: make(map[string]bool)}
+}
+
+// has reports whether name is a known miss, and the generation to add it
+// with if it isn't.
+func (c *negativeCache) has(name string) (bool, uint64) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.misses[name], c.generation
+}
+
+// add remembers name as a miss, unless the cache was cleared since
+// generation.
+func (c *negativeCache) add(name string, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if len(c.misses) >= NEGATIVE_CACHE_SIZE {
+		c.misses = make(map[string]bool)
+	}
+	c.misses[name] = true
+}
+
+// clear forgets every miss, as the records have changed.
+func (c *negativeCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.misses = make(map[string]bool)
+	c.generation++
+}
+
+// negativeTTL is the TTL of negative answers.
+func (s *NameServer) negativeTTL() uint32 {
+	if s.NegativeTTL > 0 {
+		return uint32(s.NegativeTTL / time.Second)
+	}
+	return uint32(NEGATIVE_TTL / time.Second)
+}
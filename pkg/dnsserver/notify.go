@@ -0,0 +1,52 @@
+package dnsserver
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// NOTIFY_TIMEOUT is how long a secondary has to acknowledge a NOTIFY, and
+// NOTIFY_ATTEMPTS how many times it is sent before giving up (RFC 1996).
+const (
+	NOTIFY_TIMEOUT  = 5 * time.Second
+	NOTIFY_ATTEMPTS = 5
+)
+
+// notifySecondaries tells each of NotifyAddresses that the zone changed to
+// serial, so they transfer it now rather than when their SOA refresh timer
+// expires. They are sent in the background, as a secondary that is down
+// shouldn't hold up the refresh.
+func (s *NameServer) notifySecondaries(serial uint32) {
+	for _, address := range s.NotifyAddresses {
+		go func(address string) {
+			if err := s.notify(address, serial); err != nil {
+				log.Printf("WARN: notifying %s of serial %d: %s", address, serial, err)
+			}
+		}(address)
+	}
+}
+
+// notify sends a NOTIFY for serial to the secondary at address, until it
+// acknowledges it or NOTIFY_ATTEMPTS have timed out.
+func (s *NameServer) notify(address string, serial uint32) error {
+	request := new(dns.Msg)
+	request.SetNotify(s.domain)
+	request.Answer = []dns.RR{s.soa(serial)}
+
+	client := &dns.Client{Timeout: NOTIFY_TIMEOUT}
+	var err error
+	for attempt := 0; attempt < NOTIFY_ATTEMPTS; attempt++ {
+		var r *dns.Msg
+		if r, _, err = client.Exchange(request, address); err != nil {
+			continue
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			return fmt.Errorf("answered %s", dns.RcodeToString[r.Rcode])
+		}
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package dnsserver
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// NS_TTL is the TTL of the domain's NS records.
+const NS_TTL = 300
+
+// ParseNameServers turns a list of hostnames, as given to --nameServers,
+// into fully qualified names, skipping empty ones.
+func ParseNameServers(hostnames []string) []string {
+	var names []string
+	for _, hostname := range hostnames {
+		if hostname = strings.TrimSpace(hostname); hostname != "" {
+			names = append(names, dns.Fqdn(strings.ToLower(hostname)))
+		}
+	}
+	return names
+}
+
+// nameServers are the names in the domain's NS records: NameServers, or
+// this server's hostname if there are none.
+func (s *NameServer) nameServers() []string {
+	if len(s.NameServers) > 0 {
+		return s.NameServers
+	}
+	return []string{s.hostname}
+}
+
+// NS answers for the domain's NS records.
+func (s *NameServer) NS() []dns.RR {
+	var answers []dns.RR
+	for _, name := range s.nameServers() {
+		answers = append(answers, &dns.NS{
+			Hdr: dns.RR_Header{Name: s.domain, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: NS_TTL},
+			Ns:  name,
+		})
+	}
+	return answers
+}
+
+// glue finds the addresses of the name servers inside the domain, such as
+// an instance named ns1, as client would see them, for the additional
+// section of NS answers.
+func (s *NameServer) glue(client *Client) []dns.RR {
+	var extra []dns.RR
+	for _, name := range s.nameServers() {
+		if !strings.HasSuffix(name, s.dotDomain) {
+			continue
+		}
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			for _, rr := range s.Answer(dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET}, client) {
+				if rr.Header().Rrtype == qtype && rr.Header().Name == name {
+					extra = append(extra, rr)
+				}
+			}
+		}
+	}
+	return extra
+}
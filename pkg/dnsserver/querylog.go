@@ -0,0 +1,96 @@
+package dnsserver
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// QueryLog decides which queries are logged. Each query is logged with
+// probability Sample, so 1 logs every query and 0 none. When Interval is set
+// the busiest TopN names and clients are also summarized every Interval,
+// whatever the sampling. Refusals and errors are always logged.
+type QueryLog struct {
+	Sample   float64
+	Interval time.Duration
+	TopN     int
+
+	mutex   sync.Mutex
+	names   map[string]int
+	clients map[string]int
+}
+
+// Log records that question was asked by remote in request id.
+func (ql *QueryLog) Log(question dns.Question, remote net.Addr, id uint16) {
+	if ql.Sample >= 1 || (ql.Sample > 0 && rand.Float64() < ql.Sample) {
+		log.Printf("%v %#v %v (id=%v)", dns.TypeToString[question.Qtype], question.Name, remote, id)
+	}
+	if ql.Interval <= 0 {
+		return
+	}
+
+	client := remote.String()
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	name := dns.TypeToString[question.Qtype] + " " + strings.ToLower(question.Name)
+
+	ql.mutex.Lock()
+	defer ql.mutex.Unlock()
+	if ql.names == nil {
+		ql.names, ql.clients = make(map[string]int), make(map[string]int)
+	}
+	ql.names[name]++
+	ql.clients[client]++
+}
+
+// Summarize logs the busiest names and clients every Interval, forever.
+func (ql *QueryLog) Summarize() {
+	for range time.Tick(ql.Interval) {
+		ql.mutex.Lock()
+		names, clients := ql.names, ql.clients
+		ql.names, ql.clients = nil, nil
+		ql.mutex.Unlock()
+
+		total := 0
+		for _, count := range clients {
+			total += count
+		}
+		if total == 0 {
+			continue
+		}
+		log.Printf("%d queries from %d clients in the last %s", total, len(clients), ql.Interval)
+		log.Printf("Top names: %s", topN(names, ql.TopN))
+		log.Printf("Top clients: %s", topN(clients, ql.TopN))
+	}
+}
+
+// topN formats the n keys with the highest counts, highest first.
+func topN(counts map[string]int, n int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+
+	top := make([]string, len(keys))
+	for i, key := range keys {
+		top[i] = fmt.Sprintf("%s=%d", key, counts[key])
+	}
+	return strings.Join(top, ", ")
+}
@@ -0,0 +1,125 @@
+package dnsserver
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// RESPONSE_CACHE_SIZE is the most responses a ResponseCache holds. It is
+// emptied when full, which only a flood of distinct names should cause.
+const RESPONSE_CACHE_SIZE = 10000
+
+// ResponseCache keeps packed responses to repeated queries, so hot names
+// skip building and packing their answers. Responses are kept for MaxAge at
+// most, and dropped whenever the caches refresh. Until then every client
+// gets the same answers in the same order, with the same TTLs, so MaxAge
+// should be a second or two.
+type ResponseCache struct {
+	MaxAge time.Duration
+
+	mutex   sync.RWMutex
+	entries map[responseKey]packedResponse
+	// generation counts the Clears, so responses built from records that
+	// have since been refreshed aren't kept.
+	generation uint64
+}
+
+// responseKey is everything a cached response depends on besides the id.
+type responseKey struct {
+	name             string
+	qtype, qclass    uint16
+	view             *View
+	zone             string
+	edns, do         bool
+	recursionDesired bool
+	checkingDisabled bool
+}
+
+type packedResponse struct {
+	packed  []byte
+	expires time.Time
+}
+
+// NewResponseCache creates a ResponseCache keeping responses for maxAge.
+func NewResponseCache(maxAge time.Duration) *ResponseCache {
+	return &ResponseCache{MaxAge: maxAge, entries: make(map[responseKey]packedResponse)}
+}
+
+// responseKey returns the key request's response is cached under, and false
+// if it isn't cached: anything but a single question, signed queries and
+// those with an EDNS Client Subnet option, whose answers are their own.
+func (s *NameServer) responseKey(request *dns.Msg, remote net.Addr) (responseKey, bool) {
+	if s.ResponseCache == nil || request.Opcode != dns.OpcodeQuery || len(request.Question) != 1 || request.IsTsig() != nil {
+		return responseKey{}, false
+	}
+	opt := request.IsEdns0()
+	if opt != nil && opt.Version() != 0 {
+		return responseKey{}, false
+	}
+	client := s.client(request, remote)
+	if client.subnet != nil {
+		return responseKey{}, false
+	}
+	question := request.Question[0]
+	return responseKey{
+		name:             question.Name,
+		qtype:            question.Qtype,
+		qclass:           question.Qclass,
+		view:             client.View,
+		zone:             client.Zone,
+		edns:             opt != nil,
+		do:               opt != nil && opt.Do() && s.Signer != nil,
+		recursionDesired: request.RecursionDesired,
+		checkingDisabled: request.CheckingDisabled,
+	}, true
+}
+
+// get returns a copy of the response cached for key, with its id set to id,
+// or nil if there isn't one.
+func (c *ResponseCache) get(key responseKey, id uint16) []byte {
+	c.mutex.RLock()
+	entry, ok := c.entries[key]
+	c.mutex.RUnlock()
+	if !ok || time.Now().After(entry.expires) {
+		return nil
+	}
+	packed := make([]byte, len(entry.packed))
+	copy(packed, entry.packed)
+	binary.BigEndian.PutUint16(packed, id)
+	return packed
+}
+
+// Generation is passed to put, to discard responses built before a Clear.
+func (c *ResponseCache) Generation() uint64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.generation
+}
+
+// put caches packed for key, unless the cache was cleared since generation.
+func (c *ResponseCache) put(key responseKey, packed []byte, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if len(c.entries) >= RESPONSE_CACHE_SIZE {
+		c.entries = make(map[responseKey]packedResponse)
+	}
+	c.entries[key] = packedResponse{packed: packed, expires: time.Now().Add(c.MaxAge)}
+}
+
+// Clear drops every cached response, as the records have changed.
+func (c *ResponseCache) Clear() {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[responseKey]packedResponse)
+	c.generation++
+}
@@ -0,0 +1,223 @@
+package dnsserver
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	route53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/miekg/dns"
+)
+
+// ROUTE53_BATCH is the most changes ChangeResourceRecordSets accepts at once.
+const ROUTE53_BATCH = 1000
+
+// Route53Sync pushes the zone into a Route 53 hosted zone, for networks that
+// can't delegate to aws-name-server. It owns the simple A and CNAME record
+// sets under the domain, and leaves the hosted zone's other records alone.
+type Route53Sync struct {
+	zoneID string
+	client *route53.Client
+	pushed map[string]*route53types.ResourceRecordSet
+}
+
+// NewRoute53Sync creates a Route53Sync for domain in the hosted zone with id
+// zoneID, starting from the record sets it owns there already.
+func NewRoute53Sync(zoneID string, domain string) (*Route53Sync, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	r := &Route53Sync{
+		zoneID: zoneID,
+		client: route53.NewFromConfig(cfg),
+		pushed: make(map[string]*route53types.ResourceRecordSet),
+	}
+	if err := r.listPushed(dns.Fqdn(domain)); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// listPushed takes the A and CNAME record sets under domain already in the
+// hosted zone, such as those pushed before a restart, as pushed. Those that
+// have gone are then deleted, and a name can change type.
+func (r *Route53Sync) listPushed(domain string) error {
+	input := &route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(r.zoneID)}
+	for {
+		page, err := r.client.ListResourceRecordSets(context.Background(), input)
+		if err != nil {
+			return err
+		}
+		for i := range page.ResourceRecordSets {
+			set := &page.ResourceRecordSets[i]
+			// aliases and other routing policies are never pushed
+			if set.AliasTarget != nil || set.SetIdentifier != nil || (set.Type != route53types.RRTypeA && set.Type != route53types.RRTypeCname) {
+				continue
+			}
+			// Route 53 lists wildcards' * escaped
+			name := strings.Replace(aws.ToString(set.Name), `\052`, "*", 1)
+			if !dns.IsSubDomain(domain, name) {
+				continue
+			}
+			set.Name = aws.String(name)
+			values := set.ResourceRecords
+			sort.Slice(values, func(i, j int) bool { return aws.ToString(values[i].Value) < aws.ToString(values[j].Value) })
+			r.pushed[route53Key(set)] = set
+		}
+		if !page.IsTruncated {
+			return nil
+		}
+		input.StartRecordName, input.StartRecordType, input.StartRecordIdentifier = page.NextRecordName, page.NextRecordType, page.NextRecordIdentifier
+	}
+}
+
+// Push deletes the record sets that have gone since the last push, then
+// upserts those in rrs that changed.
+func (r *Route53Sync) Push(rrs []dns.RR) error {
+	sets := route53Sets(rrs)
+
+	// deletes go first, so a name's A records can replace its CNAME or
+	// the other way around
+	var changes []route53types.Change
+	for key, old := range r.pushed {
+		if _, ok := sets[key]; !ok {
+			changes = append(changes, route53types.Change{Action: route53types.ChangeActionDelete, ResourceRecordSet: old})
+		}
+	}
+	for key, set := range sets {
+		if old, ok := r.pushed[key]; !ok || !route53Equal(old, set) {
+			changes = append(changes, route53types.Change{Action: route53types.ChangeActionUpsert, ResourceRecordSet: set})
+		}
+	}
+
+	for len(changes) > 0 {
+		batch := changes
+		if len(batch) > ROUTE53_BATCH {
+			batch = batch[:ROUTE53_BATCH]
+		}
+		changes = changes[len(batch):]
+
+		_, err := r.client.ChangeResourceRecordSets(context.Background(), &route53.ChangeResourceRecordSetsInput{
+			HostedZoneId: aws.String(r.zoneID),
+			ChangeBatch:  &route53types.ChangeBatch{Changes: batch},
+		})
+		if err != nil {
+			return err
+		}
+		// remember each batch as it lands, so a later failure doesn't repeat its deletes
+		for _, change := range batch {
+			key := route53Key(change.ResourceRecordSet)
+			if change.Action == route53types.ChangeActionDelete {
+				delete(r.pushed, key)
+			} else {
+				r.pushed[key] = change.ResourceRecordSet
+			}
+		}
+		log.Printf("Pushed %d changes to Route 53 zone %s", len(batch), r.zoneID)
+	}
+	return nil
+}
+
+// route53Sets groups rrs into Route 53 record sets by name and type. Route 53
+// allows neither several CNAMEs for a name nor a CNAME alongside other
+// records, so those conflicts are resolved in favour of the A records and
+// then the first CNAME target.
+func route53Sets(rrs []dns.RR) map[string]*route53types.ResourceRecordSet {
+	sets := make(map[string]*route53types.ResourceRecordSet)
+	for _, rr := range rrs {
+		var value string
+		switch rr := rr.(type) {
+		case *dns.A:
+			value = rr.A.String()
+		case *dns.CNAME:
+			value = rr.Target
+		default:
+			continue
+		}
+		set := &route53types.ResourceRecordSet{
+			Name: aws.String(rr.Header().Name),
+			Type: route53types.RRType(dns.TypeToString[rr.Header().Rrtype]),
+			TTL:  aws.Int64(int64(rr.Header().Ttl)),
+		}
+		key := route53Key(set)
+		if existing, ok := sets[key]; ok {
+			set = existing
+		}
+		set.ResourceRecords = append(set.ResourceRecords, route53types.ResourceRecord{Value: aws.String(value)})
+		sets[key] = set
+	}
+
+	for key, set := range sets {
+		// sort the values so unchanged sets compare equal between pushes
+		values := set.ResourceRecords
+		sort.Slice(values, func(i, j int) bool { return aws.ToString(values[i].Value) < aws.ToString(values[j].Value) })
+		values = dedupeResourceRecords(values)
+		set.ResourceRecords = values
+
+		if set.Type != route53types.RRTypeCname {
+			continue
+		}
+		if _, ok := sets[strings.TrimSuffix(key, "CNAME")+"A"]; ok {
+			log.Printf("WARN: not pushing CNAME %s to Route 53 as it also has A records", aws.ToString(set.Name))
+			delete(sets, key)
+		} else if len(values) > 1 {
+			log.Printf("WARN: pushing only the first of %d CNAMEs for %s to Route 53", len(values), aws.ToString(set.Name))
+			set.ResourceRecords = values[:1]
+		}
+	}
+	return sets
+}
+
+// dedupeResourceRecords removes adjacent duplicates from sorted values, such
+// as an instance served both by Name and by a wildcard.
+func dedupeResourceRecords(values []route53types.ResourceRecord) []route53types.ResourceRecord {
+	var deduped []route53types.ResourceRecord
+	for i, value := range values {
+		if i == 0 || aws.ToString(value.Value) != aws.ToString(values[i-1].Value) {
+			deduped = append(deduped, value)
+		}
+	}
+	return deduped
+}
+
+// route53Key identifies a record set by its name and type.
+func route53Key(set *route53types.ResourceRecordSet) string {
+	return aws.ToString(set.Name) + " " + string(set.Type)
+}
+
+// route53Equal compares the TTLs and sorted values of two record sets.
+func route53Equal(a, b *route53types.ResourceRecordSet) bool {
+	if aws.ToInt64(a.TTL) != aws.ToInt64(b.TTL) || len(a.ResourceRecords) != len(b.ResourceRecords) {
+		return false
+	}
+	for i := range a.ResourceRecords {
+		if aws.ToString(a.ResourceRecords[i].Value) != aws.ToString(b.ResourceRecords[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// PushRoute53 pushes the current zone content to Route 53, e.g. as soon
+// as Route53 is set rather than at the next change.
+func (s *NameServer) PushRoute53() {
+	s.zoneMutex.Lock()
+	defer s.zoneMutex.Unlock()
+	s.pushRoute53()
+}
+
+// pushRoute53 pushes the current zone content to Route 53, if enabled.
+func (s *NameServer) pushRoute53() {
+	if s.Route53 == nil {
+		return
+	}
+	_, rrs := s.journal.Snapshot()
+	if err := s.Route53.Push(rrs); err != nil {
+		log.Printf("ERROR: pushing to Route 53 zone %s: %s", s.Route53.zoneID, err)
+	}
+}
@@ -0,0 +1,274 @@
+package dnsserver
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SECONDARY_RETRY is how soon a secondary tries again after failing to
+// reach its primary, until it has the primary's SOA to say otherwise.
+const SECONDARY_RETRY = 30 * time.Second
+
+// MAX_CNAME_CHAIN is how many CNAMEs a secondary follows within the zone.
+const MAX_CNAME_CHAIN = 8
+
+// Secondary serves a copy of the zone of a primary aws-name-server instead
+// of discovering records from AWS, so read-only replicas need no AWS
+// credentials. The zone is transferred by IXFR, or AXFR when that isn't
+// possible, whenever the primary's SOA refresh timer expires or it sends a
+// NOTIFY. Until the first transfer succeeds, and once the SOA expire time
+// has passed since the primary was last reached, queries fail with
+// SERVFAIL rather than answering with nothing.
+type Secondary struct {
+	// Primary is the address to transfer the zone from, e.g. 10.0.0.5:53.
+	Primary string
+	// TSIGKey names the key of TSIGSecrets to sign transfers with, which
+	// are unsigned if it is empty.
+	TSIGKey string
+
+	mutex sync.RWMutex
+	// soa is the primary's SOA, nil until the first transfer.
+	soa *dns.SOA
+	// names indexes the zone by lower case name.
+	names map[string][]dns.RR
+	// checked is when the primary's serial was last fetched.
+	checked time.Time
+
+	notify chan struct{}
+}
+
+// NewSecondary creates a Secondary of the aws-name-server at primary, which
+// gets port 53 if it has none.
+func NewSecondary(primary string, tsigKey string) *Secondary {
+	if _, _, err := net.SplitHostPort(primary); err != nil {
+		primary = net.JoinHostPort(primary, "53")
+	}
+	if tsigKey != "" {
+		tsigKey = dns.CanonicalName(tsigKey)
+	}
+	return &Secondary{Primary: primary, TSIGKey: tsigKey, notify: make(chan struct{}, 1)}
+}
+
+// ready returns whether the secondary has a zone that hasn't expired.
+func (secondary *Secondary) ready() bool {
+	secondary.mutex.RLock()
+	defer secondary.mutex.RUnlock()
+
+	return secondary.soa != nil && time.Since(secondary.checked) < time.Duration(secondary.soa.Expire)*time.Second
+}
+
+// primarySOA returns a copy of the primary's SOA, or nil before the first
+// transfer.
+func (secondary *Secondary) primarySOA() *dns.SOA {
+	secondary.mutex.RLock()
+	defer secondary.mutex.RUnlock()
+
+	if secondary.soa == nil {
+		return nil
+	}
+	return dns.Copy(secondary.soa).(*dns.SOA)
+}
+
+// answer finds the records for msg in the transferred zone, following
+// CNAMEs within it.
+func (secondary *Secondary) answer(msg dns.Question) []dns.RR {
+	secondary.mutex.RLock()
+	defer secondary.mutex.RUnlock()
+
+	var answers []dns.RR
+	name := strings.ToLower(msg.Name)
+	for i := 0; i < MAX_CNAME_CHAIN; i++ {
+		var cname *dns.CNAME
+		for _, rr := range secondary.names[name] {
+			if msg.Qtype == dns.TypeANY || rr.Header().Rrtype == msg.Qtype {
+				answers = append(answers, rr)
+			} else if target, ok := rr.(*dns.CNAME); ok {
+				cname = target
+			}
+		}
+		if cname == nil || msg.Qtype == dns.TypeANY || msg.Qtype == dns.TypeCNAME {
+			break
+		}
+		answers = append(answers, cname)
+		name = strings.ToLower(cname.Target)
+	}
+	return answers
+}
+
+// Notify asks the secondary to check the primary for changes, as on a
+// NOTIFY. Checks already asked for aren't repeated.
+func (secondary *Secondary) Notify() {
+	select {
+	case secondary.notify <- struct{}{}:
+	default:
+	}
+}
+
+// FollowPrimary keeps the zone of s, a secondary, up to date with its
+// primary: checking it when the SOA refresh timer expires, or the retry
+// timer if that failed, and whenever it is notified.
+func (s *NameServer) FollowPrimary() {
+	for {
+		wait := SECONDARY_RETRY
+		err := s.TransferZone()
+		if soa := s.Secondary.primarySOA(); soa != nil {
+			if err != nil {
+				wait = time.Duration(soa.Retry) * time.Second
+			} else {
+				wait = time.Duration(soa.Refresh) * time.Second
+			}
+		}
+		if err != nil {
+			log.Printf("ERROR: transferring %s from %s: %s, retrying in %s", s.domain, s.Secondary.Primary, err, wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-s.Secondary.notify:
+		}
+	}
+}
+
+// TransferZone brings the zone of s, a secondary, up to date with its
+// primary, if the primary's serial has changed.
+func (s *NameServer) TransferZone() error {
+	s.zoneMutex.Lock()
+	defer s.zoneMutex.Unlock()
+
+	secondary := s.Secondary
+	current := secondary.primarySOA()
+
+	request := new(dns.Msg)
+	if current == nil {
+		request.SetAxfr(s.domain)
+	} else {
+		request.SetIxfr(s.domain, s.journal.Serial(), current.Ns, current.Mbox)
+	}
+	transfer := new(dns.Transfer)
+	if secondary.TSIGKey != "" {
+		secret, ok := s.TSIGSecrets[secondary.TSIGKey]
+		if !ok {
+			return fmt.Errorf("no TSIG key named %s", secondary.TSIGKey)
+		}
+		transfer.TsigSecret = map[string]string{secondary.TSIGKey: secret}
+		request.SetTsig(secondary.TSIGKey, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+
+	envelopes, err := transfer.In(request, secondary.Primary)
+	if err != nil {
+		return err
+	}
+	var rrs []dns.RR
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return envelope.Error
+		}
+		rrs = append(rrs, envelope.RR...)
+	}
+
+	soa, zone, err := s.applyTransfer(rrs)
+	if err != nil {
+		return err
+	}
+	changed := s.journal.Replace(soa.Serial, zone)
+	if changed || current == nil {
+		secondary.load(soa, zone)
+		s.ResponseCache.Clear()
+		log.Printf("Zone %s transferred from %s, serial is now %d", s.domain, secondary.Primary, soa.Serial)
+		s.notifySecondaries(soa.Serial)
+	} else {
+		secondary.load(soa, nil)
+	}
+	return nil
+}
+
+// applyTransfer turns the records of an AXFR or IXFR response into the
+// primary's SOA and the zone it leaves the journal with.
+func (s *NameServer) applyTransfer(rrs []dns.RR) (*dns.SOA, []dns.RR, error) {
+	if len(rrs) == 0 {
+		return nil, nil, fmt.Errorf("empty transfer")
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, nil, fmt.Errorf("transfer doesn't start with an SOA")
+	}
+
+	// a lone SOA says the journal is up to date
+	_, zone := s.journal.Snapshot()
+	if len(rrs) == 1 {
+		return soa, zone, nil
+	}
+	if _, ok := rrs[len(rrs)-1].(*dns.SOA); !ok {
+		return nil, nil, fmt.Errorf("transfer doesn't end with an SOA")
+	}
+
+	// an AXFR, or an IXFR answered with the whole zone
+	if _, incremental := rrs[1].(*dns.SOA); !incremental || len(rrs) == 2 {
+		return soa, rrs[1 : len(rrs)-1], nil
+	}
+
+	// an IXFR: for each change, the old SOA, the removed records, the new
+	// SOA and the added records
+	records := make(map[string]dns.RR, len(zone))
+	for _, rr := range zone {
+		records[rr.String()] = rr
+	}
+	adding := false
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if _, ok := rr.(*dns.SOA); ok {
+			adding = !adding
+			continue
+		}
+		if adding {
+			records[rr.String()] = rr
+		} else {
+			delete(records, rr.String())
+		}
+	}
+	zone = make([]dns.RR, 0, len(records))
+	for _, rr := range records {
+		zone = append(zone, rr)
+	}
+	return soa, zone, nil
+}
+
+// load records that the primary was reached and has soa, and if zone isn't
+// nil, indexes it to answer from.
+func (secondary *Secondary) load(soa *dns.SOA, zone []dns.RR) {
+	secondary.mutex.Lock()
+	defer secondary.mutex.Unlock()
+
+	secondary.soa = soa
+	secondary.checked = time.Now()
+	if zone == nil {
+		return
+	}
+	names := make(map[string][]dns.RR)
+	for _, rr := range zone {
+		name := strings.ToLower(rr.Header().Name)
+		names[name] = append(names[name], rr)
+	}
+	secondary.names = names
+}
+
+// notified answers a NOTIFY, checking the primary for changes if s is a
+// secondary. Any client the server answers may send one, as the check only
+// ever asks the configured primary.
+func (s *NameServer) notified(w dns.ResponseWriter, request *dns.Msg) {
+	if s.Secondary == nil {
+		w.WriteMsg(new(dns.Msg).SetRcode(request, dns.RcodeNotImplemented))
+		return
+	}
+	log.Printf("NOTIFY from %v (id=%v), checking %s", w.RemoteAddr(), request.Id, s.Secondary.Primary)
+	s.Secondary.Notify()
+
+	r := new(dns.Msg)
+	r.SetReply(request)
+	r.Authoritative = true
+	w.WriteMsg(r)
+}
@@ -0,0 +1,51 @@
+package dnsserver
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+// serving records a listener so Shutdown can drain it.
+func (s *NameServer) serving(server interface{}) {
+	s.serversMutex.Lock()
+	defer s.serversMutex.Unlock()
+
+	switch server := server.(type) {
+	case *dns.Server:
+		s.dnsServers = append(s.dnsServers, server)
+	case *http.Server:
+		s.httpServers = append(s.httpServers, server)
+	}
+}
+
+// Shutdown stops the listeners accepting queries and waits for those in
+// flight to be answered, or for ctx to be done.
+func (s *NameServer) Shutdown(ctx context.Context) {
+	s.serversMutex.Lock()
+	dnsServers, httpServers := s.dnsServers, s.httpServers
+	s.serversMutex.Unlock()
+
+	done := make(chan struct{})
+	for _, server := range dnsServers {
+		go func(server *dns.Server) {
+			if err := server.ShutdownContext(ctx); err != nil {
+				log.Printf("WARN: shutting down %s/%s: %s", server.Addr, server.Net, err)
+			}
+			done <- struct{}{}
+		}(server)
+	}
+	for _, server := range httpServers {
+		go func(server *http.Server) {
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("WARN: shutting down %s: %s", server.Addr, err)
+			}
+			done <- struct{}{}
+		}(server)
+	}
+	for i := 0; i < len(dnsServers)+len(httpServers); i++ {
+		<-done
+	}
+}
@@ -0,0 +1,118 @@
+package dnsserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// SOA_REFRESH, SOA_RETRY and SOA_EXPIRE are the SOA's timers, unless
+// SOASettings sets them.
+const (
+	SOA_REFRESH = 24 * time.Hour
+	SOA_RETRY   = 2 * time.Hour
+	SOA_EXPIRE  = 24 * time.Hour
+)
+
+// SOA_HOSTMASTER is the SOA's mailbox, unless SOASettings sets it.
+const SOA_HOSTMASTER = "hostmaster."
+
+// SerialStrategy is how the SOA serial is bumped when the zone changes.
+type SerialStrategy string
+
+const (
+	// SERIAL_UNIXTIME uses the unix time, or the last serial plus one if
+	// the clock is behind it.
+	SERIAL_UNIXTIME SerialStrategy = "unixtime"
+	// SERIAL_INCREMENT adds one to the last serial.
+	SERIAL_INCREMENT SerialStrategy = "increment"
+)
+
+// SOASettings are the fields of the domain's SOA record, from the config
+// file. Those left empty keep their defaults. The MINIMUM is NegativeTTL.
+type SOASettings struct {
+	// Hostmaster is the zone's contact, as a domain name such as
+	// "hostmaster.example.com" or an email address.
+	Hostmaster string
+	// Refresh, Retry and Expire are the secondaries' timers.
+	Refresh cache.Duration
+	Retry   cache.Duration
+	Expire  cache.Duration
+	// Minimum is the NegativeTTL, unless --negativeTTL is set.
+	Minimum cache.Duration
+	// Serial is the SerialStrategy, SERIAL_UNIXTIME if empty.
+	Serial SerialStrategy
+}
+
+// ParseSOASettings checks settings, and normalizes Hostmaster to a fully
+// qualified domain name.
+func ParseSOASettings(settings *SOASettings) error {
+	switch settings.Serial {
+	case "", SERIAL_UNIXTIME, SERIAL_INCREMENT:
+	default:
+		return fmt.Errorf("SOA Serial must be %s or %s, not %q", SERIAL_UNIXTIME, SERIAL_INCREMENT, settings.Serial)
+	}
+	if settings.Hostmaster == "" {
+		return nil
+	}
+	hostmaster := settings.Hostmaster
+	if at := strings.Index(hostmaster, "@"); at >= 0 {
+		hostmaster = strings.Replace(hostmaster[:at], ".", `\.`, -1) + "." + hostmaster[at+1:]
+	}
+	hostmaster = dns.Fqdn(hostmaster)
+	if _, ok := dns.IsDomainName(hostmaster); !ok {
+		return fmt.Errorf("SOA Hostmaster %q is not a valid mailbox", settings.Hostmaster)
+	}
+	settings.Hostmaster = hostmaster
+	return nil
+}
+
+// Serial returns the current SOA serial.
+func (s *NameServer) Serial() uint32 {
+	return s.journal.Serial()
+}
+
+// ResumeSerial continues numbering from serial, the last one served before
+// a restart, so secondaries see the zone change even if the clock has
+// since stepped backwards.
+func (s *NameServer) ResumeSerial(serial uint32) {
+	s.zoneMutex.Lock()
+	defer s.zoneMutex.Unlock()
+
+	s.journal.Resume(serial, s.SOASettings.Serial == SERIAL_INCREMENT)
+}
+
+func (s *NameServer) soa(serial uint32) dns.RR {
+	if s.Secondary != nil {
+		if soa := s.Secondary.primarySOA(); soa != nil {
+			soa.Serial = serial
+			return soa
+		}
+	}
+	mbox := s.SOASettings.Hostmaster
+	if mbox == "" {
+		mbox = SOA_HOSTMASTER
+	}
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: s.domain, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: s.negativeTTL()},
+		Ns:      s.hostname,
+		Serial:  serial,
+		Refresh: soaTimer(s.SOASettings.Refresh, SOA_REFRESH),
+		Retry:   soaTimer(s.SOASettings.Retry, SOA_RETRY),
+		Expire:  soaTimer(s.SOASettings.Expire, SOA_EXPIRE),
+		Minttl:  s.negativeTTL(),
+		Mbox:    mbox,
+	}
+}
+
+// soaTimer is setting in seconds, or fallback if it isn't set.
+func soaTimer(setting cache.Duration, fallback time.Duration) uint32 {
+	if setting.Duration > 0 {
+		return uint32(setting.Duration / time.Second)
+	}
+	return uint32(fallback / time.Second)
+}
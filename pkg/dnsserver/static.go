@@ -0,0 +1,83 @@
+package dnsserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// StaticRecord is a record from the config file's StaticRecords, for hosts
+// outside AWS such as an on-premises database, served alongside the
+// discovered records.
+type StaticRecord struct {
+	// Name is relative to the domain, e.g. vpn or db.onprem, @ for the
+	// domain itself, or fully qualified inside it.
+	Name string
+	// Type is the record type, e.g. A, AAAA, CNAME, TXT, MX or SRV.
+	Type string
+	// Value is the record data in zone file form, e.g. 10.1.2.3 or
+	// "10 mail.example.com.". TXT values are quoted if they aren't already.
+	Value string
+	// TTL is --ttl if zero.
+	TTL cache.Duration
+}
+
+// ParseStaticRecords turns the config file's StaticRecords into the records
+// served for each name in domain.
+func ParseStaticRecords(records []*StaticRecord, domain string) (map[string][]dns.RR, error) {
+	domain = dns.Fqdn(strings.ToLower(domain))
+	parsed := make(map[string][]dns.RR)
+	for _, record := range records {
+		name := strings.ToLower(record.Name)
+		switch {
+		case name == "@" || name == domain:
+			name = domain
+		case dns.IsFqdn(name):
+			if !dns.IsSubDomain(domain, name) {
+				return nil, fmt.Errorf("StaticRecords: %s is outside %s", record.Name, domain)
+			}
+		default:
+			name = name + "." + domain
+		}
+
+		rrtype, ok := dns.StringToType[strings.ToUpper(record.Type)]
+		if !ok || rrtype == dns.TypeSOA {
+			return nil, fmt.Errorf("StaticRecords: %s: unsupported type %q", record.Name, record.Type)
+		}
+		value := record.Value
+		if rrtype == dns.TypeTXT && !strings.HasPrefix(value, `"`) {
+			value = strconv.Quote(value)
+		}
+		ttl := record.TTL.Duration
+		if ttl == 0 {
+			ttl = cache.TTL
+		}
+
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, int(ttl/time.Second), dns.TypeToString[rrtype], value))
+		if err != nil || rr == nil {
+			return nil, fmt.Errorf("StaticRecords: %s %s %q: %v", record.Name, record.Type, record.Value, err)
+		}
+		parsed[name] = append(parsed[name], rr)
+	}
+	return parsed, nil
+}
+
+// static finds the StaticRecords for msg: those of its type, or a CNAME.
+// ANY is answered with a single HINFO whether the name is static or not.
+func (s *NameServer) static(msg dns.Question) []dns.RR {
+	if msg.Qtype == dns.TypeANY {
+		return nil
+	}
+	var answers []dns.RR
+	for _, rr := range s.StaticRecords[msg.Name] {
+		if rrtype := rr.Header().Rrtype; rrtype == msg.Qtype || rrtype == dns.TypeCNAME {
+			answers = append(answers, rr)
+		}
+	}
+	return answers
+}
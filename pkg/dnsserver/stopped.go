@@ -0,0 +1,71 @@
+package dnsserver
+
+import (
+	"strings"
+	"time"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+	"github.com/miekg/dns"
+)
+
+// STOPPED_TTL is the negative TTL of names whose instances are all stopped,
+// unless StoppedAnswer.TTL is set. Stopped instances don't come back as
+// quickly as missing ones appear, so it is longer than NEGATIVE_TTL.
+const STOPPED_TTL = 5 * time.Minute
+
+// StoppedAnswer is how names whose instances are all stopped are answered,
+// when stopped instances are discovered, so tooling can tell them apart
+// from names that don't exist. They get no records, as if they didn't
+// exist, but with a negative TTL of TTL, and with TXT set a TXT record
+// giving each instance's state: in the answer to TXT queries, and in the
+// additional section of the rest.
+type StoppedAnswer struct {
+	TTL time.Duration
+	TXT bool
+}
+
+// stopped finds the stopped instances for the name in msg, if the server
+// answers for them.
+func (s *NameServer) stopped(msg dns.Question) []*cache.Record {
+	if s.Stopped == nil || msg.Name == s.domain || isReverse(msg.Name) {
+		return nil
+	}
+	if strings.HasPrefix(msg.Name, "pub.") && msg.Name[len("pub."):] != s.domain {
+		msg.Name = msg.Name[len("pub."):]
+	}
+	return s.lookup(msg, true)
+}
+
+// answerStopped fills in r's answer to msg, for whose name the instances
+// are all stopped, signed if do is set.
+func (s *NameServer) answerStopped(r *dns.Msg, msg dns.Question, stopped []*cache.Record, do bool) {
+	ttl := uint32(STOPPED_TTL / time.Second)
+	if s.Stopped.TTL > 0 {
+		ttl = uint32(s.Stopped.TTL / time.Second)
+	}
+
+	if s.Stopped.TXT {
+		txt := &dns.TXT{
+			Hdr: dns.RR_Header{Name: msg.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+		}
+		for _, record := range stopped {
+			txt.Txt = append(txt.Txt, record.InstanceID+" stopped")
+		}
+		if msg.Qtype == dns.TypeTXT {
+			r.Answer = append(r.Answer, txt)
+			return
+		}
+		r.Extra = append(r.Extra, txt)
+	}
+
+	soa := s.SOA(msg).(*dns.SOA)
+	soa.Hdr.Ttl, soa.Minttl = ttl, ttl
+	r.Ns = append(r.Ns, soa)
+	if do {
+		var types []uint16
+		if s.Stopped.TXT {
+			types = append(types, dns.TypeTXT)
+		}
+		r.Ns = append(r.Ns, s.Signer.NSEC(msg.Name, ttl, types))
+	}
+}
@@ -0,0 +1,45 @@
+package dnsserver
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TRACER_NAME identifies the query spans.
+const TRACER_NAME = "github.com/foreflight/aws-name-server/pkg/dnsserver"
+
+// tracer creates the query spans. It is a no-op until a TracerProvider is
+// set with otel.SetTracerProvider.
+var tracer = otel.Tracer(TRACER_NAME)
+
+// startQuerySpan starts the span for answering request.
+func startQuerySpan(request *dns.Msg) trace.Span {
+	name, qtype := "", ""
+	if len(request.Question) > 0 {
+		name = request.Question[0].Name
+		qtype = dns.TypeToString[request.Question[0].Qtype]
+	}
+	_, span := tracer.Start(context.Background(), "dns.query", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("dns.question.name", name),
+			attribute.String("dns.question.type", qtype),
+		))
+	return span
+}
+
+// endQuerySpan ends the span for a query answered with rcode, or -1 if no
+// answer was sent.
+func endQuerySpan(span trace.Span, rcode int) {
+	if rcode >= 0 {
+		span.SetAttributes(attribute.String("dns.response.code", dns.RcodeToString[rcode]))
+		if rcode == dns.RcodeServerFailure {
+			span.SetStatus(codes.Error, "SERVFAIL")
+		}
+	}
+	span.End()
+}
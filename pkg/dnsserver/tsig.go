@@ -0,0 +1,95 @@
+package dnsserver
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TSIGKey is a shared secret clients can sign their queries with.
+type TSIGKey struct {
+	// Name of the key, e.g. "transfer." (made fully qualified if needed).
+	Name string
+	// Secret is the base64 encoded key, as generated by tsig-keygen.
+	Secret string
+}
+
+// TSIGSecrets converts keys into the form expected by dns.Server.
+func TSIGSecrets(keys []TSIGKey) map[string]string {
+	if len(keys) == 0 {
+		return nil
+	}
+	secrets := make(map[string]string)
+	for _, key := range keys {
+		secrets[dns.CanonicalName(key.Name)] = key.Secret
+	}
+	return secrets
+}
+
+// authorize checks a request's TSIG signature, given the status reported
+// by the server when verifying it. It returns the rcode to fail with, or
+// dns.RcodeSuccess if the request should be answered.
+func (s *NameServer) authorize(request *dns.Msg, status error) int {
+	if request.IsTsig() != nil {
+		if status != nil {
+			return dns.RcodeNotAuth
+		}
+		return dns.RcodeSuccess
+	}
+	if s.RequireTSIG {
+		return dns.RcodeRefused
+	}
+	return dns.RcodeSuccess
+}
+
+// verifyTSIG checks the signature on a packed request outside of a dns.Server,
+// e.g. for DNS-over-HTTPS.
+func (s *NameServer) verifyTSIG(request *dns.Msg, packed []byte) error {
+	t := request.IsTsig()
+	if t == nil {
+		return nil
+	}
+	secret, ok := s.TSIGSecrets[dns.CanonicalName(t.Hdr.Name)]
+	if !ok {
+		return dns.ErrSecret
+	}
+	return dns.TsigVerify(packed, secret, "", false)
+}
+
+// TSIG_MAC_SIZES are the lengths of the MACs made with each TSIG algorithm.
+// Unknown algorithms are assumed to make the longest, HMAC-SHA512's.
+var TSIG_MAC_SIZES = map[string]int{
+	dns.HmacMD5:    16,
+	dns.HmacSHA1:   20,
+	dns.HmacSHA224: 28,
+	dns.HmacSHA256: 32,
+	dns.HmacSHA384: 48,
+	dns.HmacSHA512: 64,
+}
+
+// tsigLen is how many bytes signing a reply adds to it, for a request
+// signed with t, so replies can be truncated to leave room for it.
+func tsigLen(t *dns.TSIG) int {
+	mac, ok := TSIG_MAC_SIZES[strings.ToLower(t.Algorithm)]
+	if !ok {
+		mac = TSIG_MAC_SIZES[dns.HmacSHA512]
+	}
+	// owner and algorithm names, the fixed RR header, then time signed,
+	// fudge, MAC size, MAC, original id, error and other length
+	return len(dns.Fqdn(t.Hdr.Name)) + 1 + 10 + len(dns.Fqdn(t.Algorithm)) + 1 + 6 + 2 + 2 + mac + 2 + 2 + 2
+}
+
+// packTSIG packs response, signing it if request was signed.
+func (s *NameServer) packTSIG(request, response *dns.Msg) ([]byte, error) {
+	t := request.IsTsig()
+	if t == nil {
+		return response.Pack()
+	}
+	// handleRequest adds the TSIG RR for the dns.Server to sign
+	if response.IsTsig() == nil {
+		response.SetTsig(t.Hdr.Name, t.Algorithm, t.Fudge, time.Now().Unix())
+	}
+	packed, _, err := dns.TsigGenerate(response, s.TSIGSecrets[dns.CanonicalName(t.Hdr.Name)], t.MAC, false)
+	return packed, err
+}
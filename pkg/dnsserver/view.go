@@ -0,0 +1,104 @@
+package dnsserver
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+const (
+	// ANSWER_PRIVATE serves instances' private IPs.
+	ANSWER_PRIVATE = "private"
+	// ANSWER_PUBLIC serves instances' public IPs.
+	ANSWER_PUBLIC = "public"
+	// ANSWER_PUBLIC_CNAME serves a CNAME to instances' public DNS names.
+	ANSWER_PUBLIC_CNAME = "public-cname"
+)
+
+// View decides which address clients in some subnets are given.
+type View struct {
+	Name  string
+	CIDRs []string
+	// Answer is one of "private", "public" or "public-cname".
+	Answer string
+
+	subnets []*net.IPNet
+}
+
+// PUBLIC_VIEW answers with public addresses, for pub.<name>.<domain> and --servePublic.
+var PUBLIC_VIEW = &View{Name: "public", Answer: ANSWER_PUBLIC}
+
+// DEFAULT_VIEW is used for clients that don't match any configured View,
+// unless the NameServer has a DefaultView.
+var DEFAULT_VIEW = &View{Name: "default", Answer: ANSWER_PRIVATE}
+
+// ParseViews checks the configured views and parses their subnets.
+func ParseViews(views []*View) error {
+	for _, view := range views {
+		switch view.Answer {
+		case "":
+			view.Answer = ANSWER_PRIVATE
+		case ANSWER_PRIVATE, ANSWER_PUBLIC, ANSWER_PUBLIC_CNAME:
+		default:
+			return fmt.Errorf("view %s: unknown Answer %q", view.Name, view.Answer)
+		}
+
+		subnets, err := ParseCIDRs(view.CIDRs)
+		if err != nil {
+			return fmt.Errorf("view %s: %s", view.Name, err)
+		}
+		view.subnets = subnets
+	}
+	return nil
+}
+
+// view finds the first View containing the client at remote.
+func (s *NameServer) view(remote net.Addr) *View {
+	if ip := addrIP(remote); ip != nil {
+		for _, view := range s.Views {
+			for _, subnet := range view.subnets {
+				if subnet.Contains(ip) {
+					return view
+				}
+			}
+		}
+	}
+	return s.defaultView()
+}
+
+// defaultView is the View for clients that don't match any of s.Views.
+func (s *NameServer) defaultView() *View {
+	if s.DefaultView != nil {
+		return s.DefaultView
+	}
+	return DEFAULT_VIEW
+}
+
+// addressRR is the record served as name for record in view. It returns nil
+// if the record has no suitable address, e.g. a private instance in a public view.
+func (view *View) addressRR(name string, record *cache.Record, ttl uint32) dns.RR {
+	if record.CName != "" || view.Answer == ANSWER_PRIVATE {
+		return recordRR(name, record, ttl)
+	}
+
+	if view.Answer == ANSWER_PUBLIC_CNAME {
+		if record.PublicName == "" {
+			return nil
+		}
+		return &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl},
+			Target: dns.Fqdn(record.PublicName),
+		}
+	}
+
+	if record.PublicIP == nil {
+		return nil
+	}
+	return &dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   record.PublicIP,
+	}
+}
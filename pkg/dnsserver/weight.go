@@ -0,0 +1,51 @@
+package dnsserver
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// DEFAULT_WEIGHT is the weight of records without a dns:weight tag.
+const DEFAULT_WEIGHT = 100
+
+// weight returns the record's dns:weight, or DEFAULT_WEIGHT if it isn't tagged.
+func weight(record *cache.Record) int {
+	if record.Weight == nil {
+		return DEFAULT_WEIGHT
+	}
+	return int(*record.Weight)
+}
+
+// sortByWeight shuffles records so that heavier ones are more likely to come
+// first. Records with a weight of 0 are drained: they are dropped unless every
+// record has weight 0.
+func sortByWeight(records []*cache.Record) []*cache.Record {
+	if len(records) < 2 {
+		return records
+	}
+
+	type keyed struct {
+		record *cache.Record
+		key    float64
+	}
+	weighted := make([]keyed, 0, len(records))
+	for _, record := range records {
+		if w := weight(record); w > 0 {
+			// weighted random sampling without replacement (Efraimidis-Spirakis)
+			weighted = append(weighted, keyed{record, math.Pow(rand.Float64(), 1/float64(w))})
+		}
+	}
+	if len(weighted) == 0 {
+		return records
+	}
+
+	sort.Slice(weighted, func(i, j int) bool { return weighted[i].key > weighted[j].key })
+	sorted := make([]*cache.Record, len(weighted))
+	for i, k := range weighted {
+		sorted[i] = k.record
+	}
+	return sorted
+}
@@ -0,0 +1,379 @@
+package dnsserver
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// JOURNAL_SIZE is the number of zone changes kept for IXFR. Secondaries
+// further behind than this get a full AXFR instead.
+const JOURNAL_SIZE = 100
+
+// TRANSFER_BATCH is the number of RRs sent in each message of a zone transfer.
+const TRANSFER_BATCH = 100
+
+// Delta is the change in zone content between two SOA serials.
+type Delta struct {
+	From    uint32
+	To      uint32
+	Removed []dns.RR
+	Added   []dns.RR
+}
+
+// Journal tracks the served zone across cache refreshes. It bumps the SOA
+// serial whenever the content changes and remembers recent Deltas.
+type Journal struct {
+	mutex  sync.RWMutex
+	serial uint32
+	zone   map[string]dns.RR
+	deltas []Delta
+}
+
+// NewJournal creates an empty Journal starting at the current unix time.
+func NewJournal() *Journal {
+	return &Journal{
+		serial: uint32(time.Now().Unix()),
+		zone:   make(map[string]dns.RR),
+	}
+}
+
+// Update replaces the zone content with rrs. It returns true if that changed
+// anything, in which case the serial has been incremented: by one if
+// increment is set, otherwise to the unix time if that is further ahead.
+func (journal *Journal) Update(rrs []dns.RR, increment bool) bool {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	// prefer the unix time so serials survive restarts, but never go backwards
+	serial := journal.serial + 1
+	if now := uint32(time.Now().Unix()); !increment && now > serial {
+		serial = now
+	}
+	return journal.update(rrs, serial, false)
+}
+
+// Replace replaces the zone content with rrs at serial, as transferred from
+// a primary. It returns true if either changed.
+func (journal *Journal) Replace(serial uint32, rrs []dns.RR) bool {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	return journal.update(rrs, serial, serial != journal.serial)
+}
+
+// update moves the zone to rrs at serial, if that changes its content or
+// force is set, keeping the Delta.
+func (journal *Journal) update(rrs []dns.RR, serial uint32, force bool) bool {
+	zone := make(map[string]dns.RR, len(rrs))
+	for _, rr := range rrs {
+		zone[rr.String()] = rr
+	}
+
+	delta := Delta{From: journal.serial, To: serial}
+	for key, rr := range journal.zone {
+		if _, ok := zone[key]; !ok {
+			delta.Removed = append(delta.Removed, rr)
+		}
+	}
+	for key, rr := range zone {
+		if _, ok := journal.zone[key]; !ok {
+			delta.Added = append(delta.Added, rr)
+		}
+	}
+	if len(delta.Removed) == 0 && len(delta.Added) == 0 && !force {
+		return false
+	}
+
+	journal.serial = delta.To
+	journal.zone = zone
+	journal.deltas = append(journal.deltas, delta)
+	if len(journal.deltas) > JOURNAL_SIZE {
+		journal.deltas = journal.deltas[len(journal.deltas)-JOURNAL_SIZE:]
+	}
+	return true
+}
+
+// Resume moves the serial on from serial, the last one served before a
+// restart: to serial plus one if increment is set, otherwise only if the
+// journal's is behind it. The deltas from before are forgotten, as no
+// secondary has seen them.
+func (journal *Journal) Resume(serial uint32, increment bool) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	if increment || serial >= journal.serial {
+		journal.serial = serial + 1
+		journal.deltas = nil
+	}
+}
+
+// Serial returns the current SOA serial.
+func (journal *Journal) Serial() uint32 {
+	journal.mutex.RLock()
+	defer journal.mutex.RUnlock()
+
+	return journal.serial
+}
+
+// Snapshot returns the current serial and zone content.
+func (journal *Journal) Snapshot() (uint32, []dns.RR) {
+	journal.mutex.RLock()
+	defer journal.mutex.RUnlock()
+
+	rrs := make([]dns.RR, 0, len(journal.zone))
+	for _, rr := range journal.zone {
+		rrs = append(rrs, rr)
+	}
+	sort.Slice(rrs, func(i, j int) bool { return rrs[i].Header().Name < rrs[j].Header().Name })
+	return journal.serial, rrs
+}
+
+// Since returns the Deltas needed to bring a secondary at serial up to date.
+// It returns false if serial is too old to be in the journal.
+func (journal *Journal) Since(serial uint32) ([]Delta, bool) {
+	journal.mutex.RLock()
+	defer journal.mutex.RUnlock()
+
+	if serial == journal.serial {
+		return nil, true
+	}
+	for i, delta := range journal.deltas {
+		if delta.From == serial {
+			return append([]Delta(nil), journal.deltas[i:]...), true
+		}
+	}
+	return nil, false
+}
+
+// zone builds every record the server can answer, across all caches, both
+// in the flat namespace and under each account's nickname, with the apex NS
+// records and their glue. Positional <n>.<name> names are left out since
+// they can be derived.
+func (s *NameServer) zone() []dns.RR {
+	var rrs []dns.RR
+	for _, c := range s.caches.All() {
+		for key, records := range c.Records() {
+			for _, name := range []string{cache.KeyName(key, s.domain), cache.KeyName(key, c.Nickname()+"."+s.domain)} {
+				for _, record := range records {
+					ttl := cache.TTL
+					if record.TTLOverride > 0 {
+						ttl = record.TTLOverride
+					}
+					rrs = append(rrs, recordRR(name, record, uint32(ttl/time.Second)))
+				}
+			}
+		}
+	}
+	for name, record := range s.CNAMEs {
+		rrs = append(rrs, recordRR(name+"."+s.domain, record, uint32(cache.TTL/time.Second)))
+	}
+	for _, static := range s.StaticRecords {
+		rrs = append(rrs, static...)
+	}
+	rrs = append(rrs, s.NS()...)
+	rrs = append(rrs, s.zoneGlue(rrs)...)
+	return withoutConflictingCNAMEs(rrs)
+}
+
+// zoneGlue is the addresses of the name servers in the domain that rrs
+// doesn't already have, such as this server's own hostname. They are given
+// NS_TTL rather than a TTL counting down, which would change the zone on
+// every update.
+func (s *NameServer) zoneGlue(rrs []dns.RR) []dns.RR {
+	addressed := make(map[string]bool)
+	for _, rr := range rrs {
+		if rrtype := rr.Header().Rrtype; rrtype == dns.TypeA || rrtype == dns.TypeAAAA {
+			addressed[rr.Header().Name] = true
+		}
+	}
+	var glue []dns.RR
+	for _, rr := range s.glue(&Client{View: s.defaultView()}) {
+		if addressed[rr.Header().Name] {
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = NS_TTL
+		glue = append(glue, rr)
+	}
+	return glue
+}
+
+// withoutConflictingCNAMEs drops the CNAMEs at names that have other
+// records, such as a Name shared by instances in several accounts, and all
+// but the first at names with several, as secondaries reject zones with
+// them (RFC 1034).
+func withoutConflictingCNAMEs(rrs []dns.RR) []dns.RR {
+	others := make(map[string]bool)
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeCNAME {
+			others[rr.Header().Name] = true
+		}
+	}
+	kept := rrs[:0]
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == dns.TypeCNAME {
+			if others[rr.Header().Name] {
+				log.Printf("WARN: leaving the CNAME for %s out of the zone, as it has other records", rr.Header().Name)
+				continue
+			}
+			// only one CNAME per name either
+			others[rr.Header().Name] = true
+		}
+		kept = append(kept, rr)
+	}
+	return kept
+}
+
+// recordRR is the A or CNAME record for record served as name.
+func recordRR(name string, record *cache.Record, ttl uint32) dns.RR {
+	if record.CName != "" {
+		return &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl},
+			Target: record.CName,
+		}
+	}
+	return &dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   record.PrivateIP,
+	}
+}
+
+// UpdateZone rebuilds the zone after the server's configuration changed, so
+// that NameServers, CNAMEs and StaticRecords set after NewNameServer are
+// transferred, dumped and pushed before the next cache refresh.
+func (s *NameServer) UpdateZone() {
+	s.updateZone()
+}
+
+// updateZone records the current zone content in the journal.
+func (s *NameServer) updateZone() {
+	if s.Secondary != nil {
+		return
+	}
+	s.zoneMutex.Lock()
+	defer s.zoneMutex.Unlock()
+
+	if s.journal.Update(s.zone(), s.SOASettings.Serial == SERIAL_INCREMENT) {
+		log.Printf("Zone %s changed, serial is now %d", s.domain, s.journal.Serial())
+		s.pushRoute53()
+		s.pushEtcd()
+		s.notifySecondaries(s.journal.Serial())
+	}
+}
+
+// transfer answers AXFR and IXFR requests over TCP. When TSIG keys are
+// configured, transfers must be signed with one of them.
+func (s *NameServer) transfer(w dns.ResponseWriter, request *dns.Msg) {
+	if len(s.TSIGSecrets) > 0 && (request.IsTsig() == nil || w.TsigStatus() != nil) {
+		log.Printf("WARN: refusing unsigned transfer to %v (id=%v)", w.RemoteAddr(), request.Id)
+		w.WriteMsg(new(dns.Msg).SetRcode(request, dns.RcodeRefused))
+		return
+	}
+
+	serial, rrs := s.journal.Snapshot()
+	soa := s.soa(serial)
+	question := request.Question[0]
+
+	var answer []dns.RR
+	if question.Qtype == dns.TypeIXFR && len(request.Ns) > 0 {
+		if client, ok := request.Ns[0].(*dns.SOA); ok {
+			if deltas, ok := s.journal.Since(client.Serial); ok {
+				answer = append(answer, soa)
+				for _, delta := range deltas {
+					answer = append(answer, s.soa(delta.From))
+					answer = append(answer, delta.Removed...)
+					answer = append(answer, s.soa(delta.To))
+					answer = append(answer, delta.Added...)
+				}
+				if len(deltas) > 0 {
+					answer = append(answer, soa)
+				}
+			}
+		}
+	}
+
+	// IXFR over UDP only gets the SOA, telling the client to retry over TCP
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+		r := new(dns.Msg)
+		r.SetReply(request)
+		r.Authoritative = true
+		if question.Qtype == dns.TypeIXFR {
+			r.Answer = []dns.RR{soa}
+		} else {
+			r.Rcode = dns.RcodeRefused
+		}
+		w.WriteMsg(r)
+		return
+	}
+
+	if answer == nil {
+		answer = append(append([]dns.RR{soa}, rrs...), soa)
+	}
+	log.Printf("%v %#v %v (id=%v) sending %d records", dns.TypeToString[question.Qtype], question.Name, w.RemoteAddr(), request.Id, len(answer))
+
+	ch := make(chan *dns.Envelope)
+	done := make(chan error, 1)
+	go func() {
+		done <- new(dns.Transfer).Out(w, request, ch)
+	}()
+
+	var err error
+send:
+	for len(answer) > 0 {
+		n := TRANSFER_BATCH
+		if n > len(answer) {
+			n = len(answer)
+		}
+		select {
+		case ch <- &dns.Envelope{RR: answer[:n]}:
+			answer = answer[n:]
+		case err = <-done:
+			break send
+		}
+	}
+	close(ch)
+	if err == nil {
+		err = <-done
+	}
+	if err != nil {
+		log.Printf("ERROR: transfer to %v: %s", w.RemoteAddr(), err)
+	}
+	w.Close()
+}
+
+// WriteZoneFile writes the zone served by s as an RFC 1035 zone file, with
+// its SOA and NS records first and the rest sorted by name, so it can be
+// loaded into another server or diffed against an earlier one.
+func (s *NameServer) WriteZoneFile(w io.Writer) error {
+	serial, rrs := s.journal.Snapshot()
+	sorted := make([]dns.RR, len(rrs))
+	copy(sorted, rrs)
+	apexNS := func(rr dns.RR) bool {
+		return rr.Header().Rrtype == dns.TypeNS && rr.Header().Name == s.domain
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if apexNS(sorted[i]) != apexNS(sorted[j]) {
+			return apexNS(sorted[i])
+		}
+		return sorted[i].String() < sorted[j].String()
+	})
+
+	fmt.Fprintf(w, "$ORIGIN %s\n", s.domain)
+	fmt.Fprintf(w, "$TTL %d\n", int(cache.TTL/time.Second))
+	fmt.Fprintln(w, s.soa(serial))
+	for _, rr := range sorted {
+		if _, err := fmt.Fprintln(w, rr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
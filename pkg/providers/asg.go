@@ -0,0 +1,40 @@
+package providers
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	autoscalingtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// AutoScalingGroups fetches the account's auto scaling groups and serves the
+// in-service instances of each as <asg-name>.asg.<domain>. Membership comes
+// from the group rather than tags, so it follows scaling events directly.
+// The instances themselves are looked up in instances, the records already
+// built from DescribeInstances.
+func AutoScalingGroups(ctx context.Context, cfg aws.Config, _ *cache.Clients, instances map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	records := make(map[cache.Key][]*cache.Record)
+
+	groups := autoscaling.NewDescribeAutoScalingGroupsPaginator(autoscaling.NewFromConfig(cfg), &autoscaling.DescribeAutoScalingGroupsInput{})
+	for groups.HasMorePages() {
+		page, err := groups.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range page.AutoScalingGroups {
+			name := cache.Sanitize(aws.ToString(group.AutoScalingGroupName))
+			for _, instance := range group.Instances {
+				if instance.LifecycleState != autoscalingtypes.LifecycleStateInService {
+					continue
+				}
+				for _, record := range instances[cache.Key{LookupTag: cache.LOOKUP_NAME, Value: aws.ToString(instance.InstanceId)}] {
+					addRecord(records, cache.Key{LookupTag: cache.LOOKUP_ASG, Value: name}, record)
+				}
+			}
+		}
+	}
+
+	return records, nil
+}
@@ -0,0 +1,58 @@
+package providers
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/servicediscovery"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// CloudMapServices fetches the instances registered with every Cloud Map
+// service and serves them as <service>.<domain>, alongside any EC2 instances
+// with the same Name. Instances without an AWS_INSTANCE_IPV4 attribute (e.g.
+// CNAME or HTTP-only registrations) are skipped.
+func CloudMapServices(ctx context.Context, cfg aws.Config, _ *cache.Clients, _ map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	records := make(map[cache.Key][]*cache.Record)
+	client := servicediscovery.NewFromConfig(cfg)
+
+	services := servicediscovery.NewListServicesPaginator(client, &servicediscovery.ListServicesInput{})
+	for services.HasMorePages() {
+		page, err := services.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range page.Services {
+			name := cache.Sanitize(aws.ToString(service.Name))
+			instances := servicediscovery.NewListInstancesPaginator(client, &servicediscovery.ListInstancesInput{ServiceId: service.Id})
+			for instances.HasMorePages() {
+				page, err := instances.NextPage(ctx)
+				if err != nil {
+					return nil, err
+				}
+				for _, instance := range page.Instances {
+					ip := net.ParseIP(instance.Attributes["AWS_INSTANCE_IPV4"])
+					if ip == nil {
+						continue
+					}
+					record := &cache.Record{
+						Name:             name,
+						InstanceID:       aws.ToString(instance.Id),
+						PrivateIP:        ip,
+						AvailabilityZone: instance.Attributes["AVAILABILITY_ZONE"],
+						ValidUntil:       time.Now().Add(cache.TTL),
+					}
+					if port, err := strconv.ParseUint(instance.Attributes["AWS_INSTANCE_PORT"], 10, 16); err == nil {
+						record.Port = uint16(port)
+					}
+					addRecord(records, cache.Key{LookupTag: cache.LOOKUP_NAME, Value: name}, record)
+				}
+			}
+		}
+	}
+
+	return records, nil
+}
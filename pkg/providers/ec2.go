@@ -0,0 +1,327 @@
+package providers
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/miekg/dns"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// WEIGHT_TAG is the tag used to give an instance a relative weight.
+const WEIGHT_TAG = "dns:weight"
+
+// TTL_TAG is the tag used to give an instance its own TTL in seconds, e.g.
+// dns:ttl=5 for a service whose instances come and go.
+const TTL_TAG = "dns:ttl"
+
+// INDEX_TAG is the tag used to give an instance a fixed number for nth
+// lookups, e.g. dns:index=2 for 2.kafka.<domain>.
+const INDEX_TAG = "dns:index"
+
+// ALIASES_TAG is the tag used to serve an instance under more names, a
+// comma separated list such as dns:aliases=api,legacy-api.
+const ALIASES_TAG = "dns:aliases"
+
+// CNAME_TAG is the tag used to answer for an instance with a CNAME instead
+// of its address, e.g. dns:cname=myservice.execute-api.us-east-1.amazonaws.com
+// to give a resource the server doesn't discover a name.
+const CNAME_TAG = "dns:cname"
+
+// SRV_TAG_PREFIX marks tags of the form dns:srv:<service>=<port>.
+const SRV_TAG_PREFIX = "dns:srv:"
+
+// EXCLUDE_TAG is the tag that keeps an instance out of DNS entirely when
+// true, set by --excludeTag.
+var EXCLUDE_TAG = "dns:exclude"
+
+// INCLUDE_STOPPED also discovers stopped instances, set by --includeStopped.
+// They are kept apart from the running ones, see cache.Record.Stopped.
+var INCLUDE_STOPPED = false
+
+// STATUS_CHECKS marks instances failing their EC2 status checks unhealthy,
+// set by --statusChecks.
+var STATUS_CHECKS = false
+
+// Instances fetches the account's running EC2 instances, served by their id
+// and tags, and the stopped ones if INCLUDE_STOPPED is set.
+func Instances(ctx context.Context, cfg aws.Config, clients *cache.Clients, _ map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	client := clients.EC2(cfg)
+	instancesResult, err := describeInstances(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	records := createInstanceRecords(ctx, instancesResult)
+
+	// the instances are still served if their health can't be checked
+	if STATUS_CHECKS {
+		impaired, err := describeImpaired(ctx, client)
+		if err != nil {
+			log.Printf("WARN: can't describe instance status in %s: %s", cfg.Region, err)
+		}
+		markUnhealthy(records, impaired)
+	}
+	if TARGET_HEALTH {
+		unhealthy, err := describeUnhealthyTargets(ctx, cfg)
+		if err != nil {
+			log.Printf("WARN: can't describe target health in %s: %s", cfg.Region, err)
+		}
+		markUnhealthy(records, unhealthy)
+	}
+	if err := markFailingHealthChecks(ctx, cfg, records); err != nil {
+		log.Printf("WARN: can't get Route 53 health check status for instances in %s: %s", cfg.Region, err)
+	}
+	return records, nil
+}
+
+// markUnhealthy marks the instances in records whose id or private IP is
+// in unhealthy.
+func markUnhealthy(records map[cache.Key][]*cache.Record, unhealthy map[string]bool) {
+	if len(unhealthy) == 0 {
+		return
+	}
+	for _, list := range records {
+		for _, record := range list {
+			if unhealthy[record.InstanceID] || (record.PrivateIP != nil && unhealthy[record.PrivateIP.String()]) {
+				record.Unhealthy = true
+			}
+		}
+	}
+}
+
+// describeImpaired returns the ids of the running instances failing their
+// system or instance status checks.
+func describeImpaired(ctx context.Context, client cache.EC2API) (map[string]bool, error) {
+	impaired := make(map[string]bool)
+	pages := ec2.NewDescribeInstanceStatusPaginator(client, &ec2.DescribeInstanceStatusInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, status := range page.InstanceStatuses {
+			if isImpaired(status.SystemStatus) || isImpaired(status.InstanceStatus) {
+				impaired[aws.ToString(status.InstanceId)] = true
+			}
+		}
+	}
+	return impaired, nil
+}
+
+func isImpaired(summary *ec2types.InstanceStatusSummary) bool {
+	return summary != nil && summary.Status == ec2types.SummaryStatusImpaired
+}
+
+// describeInstances fetches every page of running instances, and stopped
+// ones if INCLUDE_STOPPED is set, into one output.
+func describeInstances(ctx context.Context, client cache.EC2API) (*ec2.DescribeInstancesOutput, error) {
+	states := []string{"running"}
+	if INCLUDE_STOPPED {
+		states = append(states, "stopped")
+	}
+	result := &ec2.DescribeInstancesOutput{}
+	pages := ec2.NewDescribeInstancesPaginator(client, &ec2.DescribeInstancesInput{
+		Filters: []ec2types.Filter{
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: states,
+			},
+		},
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result.Reservations = append(result.Reservations, page.Reservations...)
+	}
+	return result, nil
+}
+
+// createInstanceRecords serves each instance by its id, and by the value of
+// each of the tags in cache.TagLookups.
+func createInstanceRecords(ctx context.Context, instancesResult *ec2.DescribeInstancesOutput) map[cache.Key][]*cache.Record {
+	records := make(map[cache.Key][]*cache.Record)
+	for _, reservation := range instancesResult.Reservations {
+		for _, instance := range reservation.Instances {
+			if excluded(instance.Tags) {
+				continue
+			}
+			record := cache.Record{}
+			record.ValidUntil = time.Now().Add(cache.TTL)
+			record.Stopped = instance.State != nil && instance.State.Name == ec2types.InstanceStateNameStopped
+
+			if instance.PrivateIpAddress != nil {
+				record.PrivateIP = net.ParseIP(*instance.PrivateIpAddress)
+			}
+			if instance.PublicIpAddress != nil {
+				record.PublicIP = net.ParseIP(*instance.PublicIpAddress)
+			}
+			if instance.PublicDnsName != nil {
+				record.PublicName = *instance.PublicDnsName
+			}
+			if instance.LaunchTime != nil {
+				record.LaunchTime = *instance.LaunchTime
+			}
+			if instance.VpcId != nil {
+				record.VpcID = *instance.VpcId
+			}
+			if instance.Placement != nil && instance.Placement.AvailabilityZone != nil {
+				record.AvailabilityZone = *instance.Placement.AvailabilityZone
+			}
+
+			// PTR records point at the Name tag if there is one, otherwise the instance id
+			record.InstanceID = *instance.InstanceId
+			record.Name = *instance.InstanceId
+
+			// Lookup servers by instance id
+			records[cache.Key{LookupTag: cache.LOOKUP_NAME, Value: *instance.InstanceId}] = append(records[cache.Key{LookupTag: cache.LOOKUP_NAME, Value: *instance.InstanceId}], &record)
+
+			// Lookup EKS nodes by their kubernetes node name, e.g. ip-10-0-1-2.eks.internal
+			if instance.PrivateDnsName != nil && *instance.PrivateDnsName != "" && hasTag(instance.Tags, cache.EKS_NODEGROUP_TAG) {
+				node := cache.Sanitize(strings.SplitN(*instance.PrivateDnsName, ".", 2)[0])
+				records[cache.Key{LookupTag: cache.LOOKUP_EKS, Value: node}] = append(records[cache.Key{LookupTag: cache.LOOKUP_EKS, Value: node}], &record)
+			}
+
+			var aliases []string
+			for _, tag := range instance.Tags {
+				for _, lookup := range cache.TagLookups() {
+					if *tag.Key != lookup.Tag {
+						continue
+					}
+					if lookup.LookupTag == cache.LOOKUP_NAME && strings.HasPrefix(*tag.Value, "*.") {
+						wildcard := sanitizeLabels(strings.TrimPrefix(*tag.Value, "*."))
+						records[cache.Key{LookupTag: cache.LOOKUP_WILDCARD, Value: wildcard}] = append(records[cache.Key{LookupTag: cache.LOOKUP_WILDCARD, Value: wildcard}], &record)
+						continue
+					}
+					value := cache.Sanitize(*tag.Value)
+					cache.NoteSanitized(ctx, cache.Key{LookupTag: lookup.LookupTag, Value: value}, *tag.Value, *instance.InstanceId)
+					if lookup.LookupTag == cache.LOOKUP_NAME {
+						record.Name = value
+					}
+					records[cache.Key{LookupTag: lookup.LookupTag, Value: value}] = append(records[cache.Key{LookupTag: lookup.LookupTag, Value: value}], &record)
+				}
+				if *tag.Key == "Port" {
+					if port, err := strconv.ParseUint(*tag.Value, 10, 16); err == nil {
+						record.Port = uint16(port)
+					}
+				}
+				if *tag.Key == HEALTH_CHECK_TAG {
+					record.HealthCheckID = *tag.Value
+				}
+				if *tag.Key == WEIGHT_TAG {
+					if weight, err := strconv.ParseUint(*tag.Value, 10, 16); err == nil {
+						w := uint16(weight)
+						record.Weight = &w
+					}
+				}
+				if *tag.Key == TTL_TAG {
+					if ttl, err := strconv.ParseUint(*tag.Value, 10, 31); err == nil && ttl > 0 {
+						record.TTLOverride = time.Duration(ttl) * time.Second
+					}
+				}
+				if *tag.Key == CNAME_TAG && *tag.Value != "" {
+					if _, ok := dns.IsDomainName(*tag.Value); ok {
+						record.CName = dns.Fqdn(*tag.Value)
+					} else {
+						log.Printf("WARN: ignoring %s tag of %s: %q is not a valid name", CNAME_TAG, *instance.InstanceId, *tag.Value)
+					}
+				}
+				if *tag.Key == ALIASES_TAG {
+					aliases = strings.Split(*tag.Value, ",")
+				}
+				if *tag.Key == INDEX_TAG {
+					if index, err := strconv.ParseUint(*tag.Value, 10, 16); err == nil {
+						i := uint16(index)
+						record.Index = &i
+					}
+				}
+				if strings.HasPrefix(*tag.Key, SRV_TAG_PREFIX) {
+					if port, err := strconv.ParseUint(*tag.Value, 10, 16); err == nil {
+						if record.Services == nil {
+							record.Services = make(map[string]uint16)
+						}
+						record.Services[cache.Sanitize(strings.TrimPrefix(*tag.Key, SRV_TAG_PREFIX))] = uint16(port)
+					}
+				}
+			}
+
+			for _, alias := range aliases {
+				if alias = strings.TrimSpace(alias); alias == "" {
+					continue
+				}
+				key := cache.Key{LookupTag: cache.LOOKUP_NAME, Value: cache.Sanitize(alias)}
+				// an alias repeating the Name, or another alias, is served once
+				if list := records[key]; len(list) > 0 && list[len(list)-1] == &record {
+					continue
+				}
+				cache.NoteSanitized(ctx, key, alias, *instance.InstanceId)
+				records[key] = append(records[key], &record)
+			}
+		}
+	}
+	return withoutSharedCNAMEs(records)
+}
+
+// withoutSharedCNAMEs drops instances tagged with CNAME_TAG from the names
+// they share with other instances, such as a Role, as a name with a CNAME
+// can have no other records (RFC 1034). They are still served by their
+// instance id, and their Name if it is theirs alone.
+func withoutSharedCNAMEs(records map[cache.Key][]*cache.Record) map[cache.Key][]*cache.Record {
+	for key, list := range records {
+		if len(list) < 2 {
+			continue
+		}
+		var kept []*cache.Record
+		for _, record := range list {
+			if record.CName == "" {
+				kept = append(kept, record)
+			} else {
+				log.Printf("WARN: not serving %s as a CNAME under %s, which it shares with other instances", record.InstanceID, strings.TrimSuffix(cache.KeyName(key, ""), "."))
+			}
+		}
+		records[key] = kept
+		if len(kept) == 0 {
+			delete(records, key)
+		}
+	}
+	return records
+}
+
+// hasTag returns whether tags include key.
+func hasTag(tags []ec2types.Tag, key string) bool {
+	for _, tag := range tags {
+		if *tag.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded is whether the instance is tagged with EXCLUDE_TAG=true.
+func excluded(tags []ec2types.Tag) bool {
+	for _, tag := range tags {
+		if *tag.Key == EXCLUDE_TAG {
+			exclude, err := strconv.ParseBool(*tag.Value)
+			return err == nil && exclude
+		}
+	}
+	return false
+}
+
+// sanitizeLabels sanitizes each label of a dotted name.
+func sanitizeLabels(name string) string {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		labels[i] = cache.Sanitize(label)
+	}
+	return strings.Join(labels, ".")
+}
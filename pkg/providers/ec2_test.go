@@ -0,0 +1,127 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// fakeEC2 serves pages of instances from DescribeInstances, in place of EC2.
+type fakeEC2 struct {
+	cache.EC2API
+	pages [][]ec2types.Instance
+}
+
+func (client fakeEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	page := 0
+	if params.NextToken != nil {
+		page, _ = strconv.Atoi(*params.NextToken)
+	}
+	output := &ec2.DescribeInstancesOutput{
+		Reservations: []ec2types.Reservation{{Instances: client.pages[page]}},
+	}
+	if page+1 < len(client.pages) {
+		output.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return output, nil
+}
+
+// instance is a running instance with a private IP and tags, given as
+// alternating keys and values.
+func instance(id string, ip string, tags ...string) ec2types.Instance {
+	i := ec2types.Instance{
+		InstanceId:       aws.String(id),
+		PrivateIpAddress: aws.String(ip),
+		State:            &ec2types.InstanceState{Name: ec2types.InstanceStateNameRunning},
+	}
+	for n := 0; n+1 < len(tags); n += 2 {
+		i.Tags = append(i.Tags, ec2types.Tag{Key: aws.String(tags[n]), Value: aws.String(tags[n+1])})
+	}
+	return i
+}
+
+// lookup returns the private IPs of the records under key, sorted.
+func lookup(records map[cache.Key][]*cache.Record, tag cache.LookupTag, value string) []string {
+	var ips []string
+	for _, record := range records[cache.Key{LookupTag: tag, Value: value}] {
+		ips = append(ips, record.PrivateIP.String())
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+func expect(t *testing.T, name string, got []string, want []string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s: got %v, want %v", name, got, want)
+	}
+}
+
+func TestCreateInstanceRecords(t *testing.T) {
+	stopped := instance("i-stopped", "10.0.0.9", "Name", "web")
+	stopped.State.Name = ec2types.InstanceStateNameStopped
+	records := createInstanceRecords(context.Background(), &ec2.DescribeInstancesOutput{
+		Reservations: []ec2types.Reservation{{Instances: []ec2types.Instance{
+			instance("i-web1", "10.0.0.1", "Name", "web", "Role", "Frontend", INDEX_TAG, "2"),
+			instance("i-web2", "10.0.0.2", "Name", "Web", "Role", "frontend", ALIASES_TAG, "www, web,legacy"),
+			instance("i-gateway", "10.0.0.3", "Name", "gateway", "Role", "frontend", CNAME_TAG, "api.example.com"),
+			instance("i-excluded", "10.0.0.4", "Name", "web", EXCLUDE_TAG, "true"),
+			instance("i-untagged", "10.0.0.5"),
+			stopped,
+		}}},
+	})
+
+	expect(t, "name", lookup(records, cache.LOOKUP_NAME, "web"), []string{"10.0.0.1", "10.0.0.2", "10.0.0.9"})
+	expect(t, "role", lookup(records, cache.LOOKUP_ROLE, "frontend"), []string{"10.0.0.1", "10.0.0.2"})
+	expect(t, "instance id", lookup(records, cache.LOOKUP_NAME, "i-untagged"), []string{"10.0.0.5"})
+	expect(t, "alias", lookup(records, cache.LOOKUP_NAME, "legacy"), []string{"10.0.0.2"})
+	expect(t, "excluded", lookup(records, cache.LOOKUP_NAME, "i-excluded"), nil)
+
+	// the CNAME is only served where it doesn't share the name
+	if got := records[cache.Key{LookupTag: cache.LOOKUP_NAME, Value: "gateway"}]; len(got) != 1 || got[0].CName != "api.example.com." {
+		t.Errorf("gateway: got %v, want the CNAME to api.example.com.", got)
+	}
+
+	for _, record := range records[cache.Key{LookupTag: cache.LOOKUP_NAME, Value: "web"}] {
+		switch record.InstanceID {
+		case "i-web1":
+			if record.Index == nil || *record.Index != 2 {
+				t.Errorf("i-web1: got index %v, want 2", record.Index)
+			}
+		case "i-web2":
+			if record.Index != nil {
+				t.Errorf("i-web2: got index %d, want none", *record.Index)
+			}
+		case "i-stopped":
+			if !record.Stopped {
+				t.Errorf("i-stopped isn't marked Stopped")
+			}
+		}
+	}
+}
+
+func TestInstancesPages(t *testing.T) {
+	client := fakeEC2{pages: [][]ec2types.Instance{
+		{instance("i-1", "10.0.0.1", "Role", "worker")},
+		{instance("i-2", "10.0.0.2", "Role", "worker")},
+		{instance("i-3", "10.0.0.3", "Role", "worker")},
+	}}
+	clients := &cache.Clients{EC2: func(cfg aws.Config) cache.EC2API { return client }}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	records, err := Instances(ctx, aws.Config{Region: "us-east-1"}, clients, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, "every page", lookup(records, cache.LOOKUP_ROLE, "worker"), []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+}
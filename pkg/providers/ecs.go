@@ -0,0 +1,104 @@
+package providers
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecstypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// ECS_DESCRIBE_BATCH is the most tasks ecs.DescribeTasks accepts at once.
+const ECS_DESCRIBE_BATCH = 100
+
+// Tasks fetches the running ECS tasks in every cluster and serves the tasks
+// of each service as <service>.ecs.<domain>, pointing at the private IPs of
+// their network interfaces. Only tasks using awsvpc networking (including
+// all Fargate tasks) have their own IP.
+func Tasks(ctx context.Context, cfg aws.Config, _ *cache.Clients, _ map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	records := make(map[cache.Key][]*cache.Record)
+	client := ecs.NewFromConfig(cfg)
+
+	clusters := ecs.NewListClustersPaginator(client, &ecs.ListClustersInput{})
+	for clusters.HasMorePages() {
+		page, err := clusters.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, cluster := range page.ClusterArns {
+			if err := addTasks(ctx, client, cluster, records); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// addTasks adds the running tasks of the services in cluster to records.
+func addTasks(ctx context.Context, client *ecs.Client, cluster string, records map[cache.Key][]*cache.Record) error {
+	var arns []string
+	tasks := ecs.NewListTasksPaginator(client, &ecs.ListTasksInput{
+		Cluster:       aws.String(cluster),
+		DesiredStatus: ecstypes.DesiredStatusRunning,
+	})
+	for tasks.HasMorePages() {
+		page, err := tasks.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		arns = append(arns, page.TaskArns...)
+	}
+
+	for len(arns) > 0 {
+		batch := arns
+		if len(batch) > ECS_DESCRIBE_BATCH {
+			batch = batch[:ECS_DESCRIBE_BATCH]
+		}
+		arns = arns[len(batch):]
+
+		described, err := client.DescribeTasks(ctx, &ecs.DescribeTasksInput{Cluster: aws.String(cluster), Tasks: batch})
+		if err != nil {
+			return err
+		}
+		for _, task := range described.Tasks {
+			// tasks started by a service are in the group service:<name>
+			group := aws.ToString(task.Group)
+			if !strings.HasPrefix(group, "service:") || aws.ToString(task.LastStatus) != string(ecstypes.DesiredStatusRunning) {
+				continue
+			}
+			service := cache.Sanitize(strings.TrimPrefix(group, "service:"))
+			for _, ip := range taskIPs(task) {
+				addRecord(records, cache.Key{LookupTag: cache.LOOKUP_ECS, Value: service}, &cache.Record{
+					Name:             service + ".ecs",
+					PrivateIP:        ip,
+					AvailabilityZone: aws.ToString(task.AvailabilityZone),
+					ValidUntil:       time.Now().Add(cache.TTL),
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// taskIPs returns the private IPs of a task's elastic network interfaces.
+func taskIPs(task ecstypes.Task) []net.IP {
+	var ips []net.IP
+	for _, attachment := range task.Attachments {
+		if aws.ToString(attachment.Type) != "ElasticNetworkInterface" {
+			continue
+		}
+		for _, detail := range attachment.Details {
+			if aws.ToString(detail.Name) == "privateIPv4Address" {
+				if ip := net.ParseIP(aws.ToString(detail.Value)); ip != nil {
+					ips = append(ips, ip)
+				}
+			}
+		}
+	}
+	return ips
+}
@@ -0,0 +1,72 @@
+package providers
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	efstypes "github.com/aws/aws-sdk-go-v2/service/efs/types"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// FileSystems fetches the account's EFS file systems and serves their mount
+// targets as <name>.efs.<domain> (or <fs-id>.efs.<domain> if unnamed). Clients
+// in an availability zone with a mount target only get that one.
+func FileSystems(ctx context.Context, cfg aws.Config, _ *cache.Clients, _ map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	records := make(map[cache.Key][]*cache.Record)
+	client := efs.NewFromConfig(cfg)
+
+	fileSystems := efs.NewDescribeFileSystemsPaginator(client, &efs.DescribeFileSystemsInput{})
+	for fileSystems.HasMorePages() {
+		page, err := fileSystems.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, fileSystem := range page.FileSystems {
+			names := []string{cache.Sanitize(aws.ToString(fileSystem.FileSystemId))}
+			if name := aws.ToString(fileSystem.Name); name != "" {
+				names = append(names, cache.Sanitize(name))
+			}
+			if err := addMountTargets(ctx, client, fileSystem.FileSystemId, names, records); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// addMountTargets adds the available mount targets of the file system with
+// id to records, under each of names. DescribeMountTargets has no
+// paginator, so its Marker is followed by hand.
+func addMountTargets(ctx context.Context, client *efs.Client, id *string, names []string, records map[cache.Key][]*cache.Record) error {
+	input := &efs.DescribeMountTargetsInput{FileSystemId: id}
+	for {
+		targets, err := client.DescribeMountTargets(ctx, input)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets.MountTargets {
+			if target.LifeCycleState != efstypes.LifeCycleStateAvailable {
+				continue
+			}
+			record := &cache.Record{
+				Name:             names[len(names)-1] + ".efs",
+				PrivateIP:        net.ParseIP(aws.ToString(target.IpAddress)),
+				AvailabilityZone: aws.ToString(target.AvailabilityZoneName),
+				VpcID:            aws.ToString(target.VpcId),
+				ZoneLocal:        true,
+				ValidUntil:       time.Now().Add(cache.TTL),
+			}
+			for _, name := range names {
+				addRecord(records, cache.Key{LookupTag: cache.LOOKUP_EFS, Value: name}, record)
+			}
+		}
+		if aws.ToString(targets.NextMarker) == "" {
+			return nil
+		}
+		input.Marker = targets.NextMarker
+	}
+}
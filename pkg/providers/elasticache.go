@@ -0,0 +1,77 @@
+package providers
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticache"
+	elasticachetypes "github.com/aws/aws-sdk-go-v2/service/elasticache/types"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// CacheClusters fetches the account's ElastiCache replication groups and
+// clusters. Each is served as a CNAME to its primary (or configuration)
+// endpoint under <id>.cache.<domain>, and to its reader endpoint under
+// <id>.ro.cache.<domain>.
+func CacheClusters(ctx context.Context, cfg aws.Config, _ *cache.Clients, _ map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	records := make(map[cache.Key][]*cache.Record)
+	client := elasticache.NewFromConfig(cfg)
+
+	groups := elasticache.NewDescribeReplicationGroupsPaginator(client, &elasticache.DescribeReplicationGroupsInput{})
+	for groups.HasMorePages() {
+		page, err := groups.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range page.ReplicationGroups {
+			id := cache.Sanitize(aws.ToString(group.ReplicationGroupId))
+
+			if group.ConfigurationEndpoint != nil {
+				addRecord(records, cache.Key{LookupTag: cache.LOOKUP_CACHE, Value: id}, cacheEndpointRecord(id, group.ConfigurationEndpoint))
+			} else if len(group.NodeGroups) > 0 {
+				if group.NodeGroups[0].PrimaryEndpoint != nil {
+					addRecord(records, cache.Key{LookupTag: cache.LOOKUP_CACHE, Value: id}, cacheEndpointRecord(id, group.NodeGroups[0].PrimaryEndpoint))
+				}
+				if group.NodeGroups[0].ReaderEndpoint != nil {
+					addRecord(records, cache.Key{LookupTag: cache.LOOKUP_CACHE_READER, Value: id}, cacheEndpointRecord(id, group.NodeGroups[0].ReaderEndpoint))
+				}
+			}
+		}
+	}
+
+	clusters := elasticache.NewDescribeCacheClustersPaginator(client, &elasticache.DescribeCacheClustersInput{
+		ShowCacheClustersNotInReplicationGroups: aws.Bool(true),
+		ShowCacheNodeInfo:                       aws.Bool(true),
+	})
+	for clusters.HasMorePages() {
+		page, err := clusters.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, cluster := range page.CacheClusters {
+			id := cache.Sanitize(aws.ToString(cluster.CacheClusterId))
+
+			// memcached has a configuration endpoint, single node redis only has the node's
+			if cluster.ConfigurationEndpoint != nil {
+				addRecord(records, cache.Key{LookupTag: cache.LOOKUP_CACHE, Value: id}, cacheEndpointRecord(id, cluster.ConfigurationEndpoint))
+			} else if len(cluster.CacheNodes) > 0 && cluster.CacheNodes[0].Endpoint != nil {
+				addRecord(records, cache.Key{LookupTag: cache.LOOKUP_CACHE, Value: id}, cacheEndpointRecord(id, cluster.CacheNodes[0].Endpoint))
+			}
+		}
+	}
+
+	return records, nil
+}
+
+func cacheEndpointRecord(id string, endpoint *elasticachetypes.Endpoint) *cache.Record {
+	record := &cache.Record{
+		Name:       id,
+		CName:      aws.ToString(endpoint.Address) + ".",
+		ValidUntil: time.Now().Add(cache.TTL),
+	}
+	if endpoint.Port != nil {
+		record.Port = uint16(*endpoint.Port)
+	}
+	return record
+}
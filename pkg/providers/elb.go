@@ -0,0 +1,95 @@
+package providers
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elb "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// ELB_TAGS_BATCH is the most load balancers elbv2.DescribeTags accepts at once.
+const ELB_TAGS_BATCH = 20
+
+// LoadBalancers fetches the account's application, network and classic load
+// balancers, served as CNAMEs to their DNS names under <name>.lb.<domain>.
+// Application and network load balancers with a Name tag are served under
+// that too.
+func LoadBalancers(ctx context.Context, cfg aws.Config, _ *cache.Clients, _ map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	records := make(map[cache.Key][]*cache.Record)
+
+	v2 := elbv2.NewFromConfig(cfg)
+	byArn := make(map[string]*cache.Record)
+	loadBalancers := elbv2.NewDescribeLoadBalancersPaginator(v2, &elbv2.DescribeLoadBalancersInput{})
+	for loadBalancers.HasMorePages() {
+		page, err := loadBalancers.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, lb := range page.LoadBalancers {
+			if lb.DNSName == nil || lb.LoadBalancerName == nil {
+				continue
+			}
+			record := loadBalancerRecord(*lb.LoadBalancerName, *lb.DNSName)
+			addRecord(records, cache.Key{LookupTag: cache.LOOKUP_LB, Value: record.Name}, record)
+			byArn[*lb.LoadBalancerArn] = record
+		}
+	}
+
+	var arns []string
+	for arn := range byArn {
+		arns = append(arns, arn)
+	}
+	for len(arns) > 0 {
+		batch := arns
+		if len(batch) > ELB_TAGS_BATCH {
+			batch = batch[:ELB_TAGS_BATCH]
+		}
+		arns = arns[len(batch):]
+
+		tags, err := v2.DescribeTags(ctx, &elbv2.DescribeTagsInput{ResourceArns: batch})
+		if err != nil {
+			return nil, err
+		}
+		for _, description := range tags.TagDescriptions {
+			record := byArn[aws.ToString(description.ResourceArn)]
+			for _, tag := range description.Tags {
+				if aws.ToString(tag.Key) == "Name" && cache.Sanitize(aws.ToString(tag.Value)) != record.Name {
+					addRecord(records, cache.Key{LookupTag: cache.LOOKUP_LB, Value: cache.Sanitize(aws.ToString(tag.Value))}, record)
+				}
+			}
+		}
+	}
+
+	classic := elb.NewDescribeLoadBalancersPaginator(elb.NewFromConfig(cfg), &elb.DescribeLoadBalancersInput{})
+	for classic.HasMorePages() {
+		page, err := classic.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, lb := range page.LoadBalancerDescriptions {
+			if lb.DNSName == nil || lb.LoadBalancerName == nil {
+				continue
+			}
+			record := loadBalancerRecord(*lb.LoadBalancerName, *lb.DNSName)
+			addRecord(records, cache.Key{LookupTag: cache.LOOKUP_LB, Value: record.Name}, record)
+		}
+	}
+
+	return records, nil
+}
+
+func loadBalancerRecord(name, dnsName string) *cache.Record {
+	return &cache.Record{
+		Name:       cache.Sanitize(name),
+		CName:      dnsName + ".",
+		ValidUntil: time.Now().Add(cache.TTL),
+	}
+}
+
+// addRecord appends record to the records for key.
+func addRecord(records map[cache.Key][]*cache.Record, key cache.Key, record *cache.Record) {
+	records[key] = append(records[key], record)
+}
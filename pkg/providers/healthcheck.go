@@ -0,0 +1,58 @@
+package providers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// HEALTH_CHECK_TAG associates an instance with a Route 53 health check, by
+// its id. The instance is unhealthy while the health check fails.
+const HEALTH_CHECK_TAG = "dns:healthcheck"
+
+// HEALTHY_CHECKERS is the share of Route 53's health checkers that must
+// report success for a health check to be healthy, as Route 53 itself
+// decides.
+const HEALTHY_CHECKERS = 0.18
+
+// markFailingHealthChecks marks the records whose Route 53 health check is
+// failing unhealthy. Each health check is asked for its status once.
+func markFailingHealthChecks(ctx context.Context, cfg aws.Config, records map[cache.Key][]*cache.Record) error {
+	failing := make(map[string]bool)
+	client := route53.NewFromConfig(cfg)
+	for _, list := range records {
+		for _, record := range list {
+			id := record.HealthCheckID
+			if id == "" {
+				continue
+			}
+			if _, checked := failing[id]; !checked {
+				status, err := client.GetHealthCheckStatus(ctx, &route53.GetHealthCheckStatusInput{HealthCheckId: aws.String(id)})
+				if err != nil {
+					return err
+				}
+				failing[id] = !healthyObservations(status)
+			}
+			record.Unhealthy = record.Unhealthy || failing[id]
+		}
+	}
+	return nil
+}
+
+// healthyObservations returns whether more than HEALTHY_CHECKERS of the
+// health checkers in status report success.
+func healthyObservations(status *route53.GetHealthCheckStatusOutput) bool {
+	if len(status.HealthCheckObservations) == 0 {
+		return true
+	}
+	succeeded := 0
+	for _, observation := range status.HealthCheckObservations {
+		if observation.StatusReport != nil && strings.HasPrefix(aws.ToString(observation.StatusReport.Status), "Success") {
+			succeeded++
+		}
+	}
+	return float64(succeeded) > HEALTHY_CHECKERS*float64(len(status.HealthCheckObservations))
+}
@@ -0,0 +1,61 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kafka"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// Brokers fetches the account's MSK clusters and serves all their brokers as
+// <cluster>.msk.<domain>, for bootstrapping, and each broker as
+// b-<n>.<cluster>.msk.<domain>, matching the broker ids MSK uses.
+func Brokers(ctx context.Context, cfg aws.Config, _ *cache.Clients, _ map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	records := make(map[cache.Key][]*cache.Record)
+	client := kafka.NewFromConfig(cfg)
+
+	clusters := kafka.NewListClustersPaginator(client, &kafka.ListClustersInput{})
+	for clusters.HasMorePages() {
+		page, err := clusters.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, cluster := range page.ClusterInfoList {
+			if err := addBrokers(ctx, client, cluster.ClusterArn, cache.Sanitize(aws.ToString(cluster.ClusterName)), records); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// addBrokers adds the brokers of the cluster at arn, called name, to records.
+func addBrokers(ctx context.Context, client *kafka.Client, arn *string, name string, records map[cache.Key][]*cache.Record) error {
+	nodes := kafka.NewListNodesPaginator(client, &kafka.ListNodesInput{ClusterArn: arn})
+	for nodes.HasMorePages() {
+		page, err := nodes.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, node := range page.NodeInfoList {
+			broker := node.BrokerNodeInfo
+			if broker == nil || broker.BrokerId == nil {
+				continue
+			}
+			brokerName := fmt.Sprintf("b-%d.%s", int(*broker.BrokerId), name)
+			record := &cache.Record{
+				Name:       brokerName + ".msk",
+				PrivateIP:  net.ParseIP(aws.ToString(broker.ClientVpcIpAddress)),
+				ValidUntil: time.Now().Add(cache.TTL),
+			}
+			addRecord(records, cache.Key{LookupTag: cache.LOOKUP_MSK, Value: name}, record)
+			addRecord(records, cache.Key{LookupTag: cache.LOOKUP_MSK, Value: brokerName}, record)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package providers
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// ElasticIPs fetches the account's Elastic IPs and serves them as
+// <name>.eip.<domain> by their Name tag, or allocation id if untagged.
+// They always resolve to the public address, whatever the view.
+func ElasticIPs(ctx context.Context, cfg aws.Config, clients *cache.Clients, _ map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	records := make(map[cache.Key][]*cache.Record)
+
+	addresses, err := clients.EC2(cfg).DescribeAddresses(ctx, &ec2.DescribeAddressesInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range addresses.Addresses {
+		ip := net.ParseIP(aws.ToString(address.PublicIp))
+		if ip == nil {
+			continue
+		}
+		name := cache.Sanitize(tagValue(address.Tags, "Name", aws.ToString(address.AllocationId)))
+		addRecord(records, cache.Key{LookupTag: cache.LOOKUP_EIP, Value: name}, &cache.Record{
+			Name:       name + ".eip",
+			PrivateIP:  ip,
+			PublicIP:   ip,
+			ValidUntil: time.Now().Add(cache.TTL),
+		})
+	}
+
+	return records, nil
+}
+
+// NatGateways fetches the account's NAT gateways and serves them as
+// <name>.nat.<domain> by their Name tag, or NAT gateway id if untagged.
+// Private views get their private IPs and public views their public IPs.
+func NatGateways(ctx context.Context, cfg aws.Config, clients *cache.Clients, _ map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	records := make(map[cache.Key][]*cache.Record)
+
+	gateways := ec2.NewDescribeNatGatewaysPaginator(clients.EC2(cfg), &ec2.DescribeNatGatewaysInput{})
+	for gateways.HasMorePages() {
+		page, err := gateways.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, gateway := range page.NatGateways {
+			if gateway.State != ec2types.NatGatewayStateAvailable {
+				continue
+			}
+			name := cache.Sanitize(tagValue(gateway.Tags, "Name", aws.ToString(gateway.NatGatewayId)))
+			for _, address := range gateway.NatGatewayAddresses {
+				addRecord(records, cache.Key{LookupTag: cache.LOOKUP_NAT, Value: name}, &cache.Record{
+					Name:       name + ".nat",
+					PrivateIP:  net.ParseIP(aws.ToString(address.PrivateIp)),
+					PublicIP:   net.ParseIP(aws.ToString(address.PublicIp)),
+					VpcID:      aws.ToString(gateway.VpcId),
+					ValidUntil: time.Now().Add(cache.TTL),
+				})
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// tagValue returns the value of the tag called key, or def if there isn't one.
+func tagValue(tags []ec2types.Tag, key, def string) string {
+	for _, tag := range tags {
+		if aws.ToString(tag.Key) == key && aws.ToString(tag.Value) != "" {
+			return *tag.Value
+		}
+	}
+	return def
+}
@@ -0,0 +1,23 @@
+// Package providers discovers the AWS services served by aws-name-server,
+// one cache.Provider each. Importing it registers them all with the cache
+// package, in the order they are fetched.
+package providers
+
+import "github.com/foreflight/aws-name-server/pkg/cache"
+
+func init() {
+	cache.Register(&cache.Provider{Service: "rds", Fetch: Databases})
+	cache.Register(&cache.Provider{Service: "ec2", Fetch: Instances})
+	cache.Register(&cache.Provider{Service: "elb", Fetch: LoadBalancers})
+	cache.Register(&cache.Provider{Service: "elasticache", Fetch: CacheClusters})
+	cache.Register(&cache.Provider{Service: "ecs", Fetch: Tasks})
+	cache.Register(&cache.Provider{Service: "efs", Fetch: FileSystems})
+	cache.Register(&cache.Provider{Service: "msk", Fetch: Brokers})
+	cache.Register(&cache.Provider{Service: "eip", Fetch: ElasticIPs})
+	cache.Register(&cache.Provider{Service: "nat", Fetch: NatGateways})
+	cache.Register(&cache.Provider{Service: "vpce", Fetch: VpcEndpoints})
+	// after ec2 so groups can find their instances by id
+	cache.Register(&cache.Provider{Service: "asg", Fetch: AutoScalingGroups})
+	// cloud map instances share the Name namespace, so merge rather than replace
+	cache.Register(&cache.Provider{Service: "cloudmap", Fetch: CloudMapServices, Merge: true})
+}
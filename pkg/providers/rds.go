@@ -0,0 +1,94 @@
+package providers
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// Databases fetches the account's RDS instances and Aurora clusters.
+func Databases(ctx context.Context, cfg aws.Config, clients *cache.Clients, _ map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	records := make(map[cache.Key][]*cache.Record)
+	client := clients.RDS(cfg)
+
+	databaseResult, err := describeDBInstances(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range createDatabaseRecords(databaseResult) {
+		records[k] = v
+	}
+
+	clusterResult, err := describeDBClusters(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range createDatabaseClusterRecords(clusterResult) {
+		records[k] = v
+	}
+	return records, nil
+}
+
+// describeDBInstances fetches every page of RDS instances into one output.
+func describeDBInstances(ctx context.Context, client cache.RDSAPI) (*rds.DescribeDBInstancesOutput, error) {
+	result := &rds.DescribeDBInstancesOutput{}
+	pages := rds.NewDescribeDBInstancesPaginator(client, &rds.DescribeDBInstancesInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result.DBInstances = append(result.DBInstances, page.DBInstances...)
+	}
+	return result, nil
+}
+
+// describeDBClusters fetches every page of Aurora clusters into one output.
+func describeDBClusters(ctx context.Context, client cache.RDSAPI) (*rds.DescribeDBClustersOutput, error) {
+	result := &rds.DescribeDBClustersOutput{}
+	pages := rds.NewDescribeDBClustersPaginator(client, &rds.DescribeDBClustersInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result.DBClusters = append(result.DBClusters, page.DBClusters...)
+	}
+	return result, nil
+}
+
+// createDatabaseRecords serves RDS instances as CNAMEs to their endpoints.
+func createDatabaseRecords(databaseResult *rds.DescribeDBInstancesOutput) map[cache.Key][]*cache.Record {
+	records := make(map[cache.Key][]*cache.Record)
+	for _, r := range databaseResult.DBInstances {
+		record := cache.Record{}
+		if *r.Endpoint.Address != "" {
+			record.CName = *r.Endpoint.Address + "."
+			name := cache.Sanitize(*r.DBInstanceIdentifier)
+			records[cache.Key{LookupTag: cache.LOOKUP_NAME, Value: name}] = append(records[cache.Key{LookupTag: cache.LOOKUP_NAME, Value: name}], &record)
+		}
+	}
+	return records
+}
+
+// createDatabaseClusterRecords serves Aurora clusters' writer endpoints as
+// <cluster>.<domain> and their reader endpoints as <cluster>.ro.<domain>, so
+// clients follow failovers.
+func createDatabaseClusterRecords(clusterResult *rds.DescribeDBClustersOutput) map[cache.Key][]*cache.Record {
+	records := make(map[cache.Key][]*cache.Record)
+	for _, cluster := range clusterResult.DBClusters {
+		name := cache.Sanitize(*cluster.DBClusterIdentifier)
+		if cluster.Endpoint != nil && *cluster.Endpoint != "" {
+			record := cache.Record{Name: name, CName: *cluster.Endpoint + ".", ValidUntil: time.Now().Add(cache.TTL)}
+			records[cache.Key{LookupTag: cache.LOOKUP_NAME, Value: name}] = append(records[cache.Key{LookupTag: cache.LOOKUP_NAME, Value: name}], &record)
+		}
+		if cluster.ReaderEndpoint != nil && *cluster.ReaderEndpoint != "" {
+			record := cache.Record{Name: name, CName: *cluster.ReaderEndpoint + ".", ValidUntil: time.Now().Add(cache.TTL)}
+			records[cache.Key{LookupTag: cache.LOOKUP_READER, Value: name}] = append(records[cache.Key{LookupTag: cache.LOOKUP_READER, Value: name}], &record)
+		}
+	}
+	return records
+}
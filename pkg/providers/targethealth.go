@@ -0,0 +1,47 @@
+package providers
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+)
+
+// TARGET_HEALTH marks instances that a load balancer's target group won't
+// send traffic to unhealthy, set by --targetHealth.
+var TARGET_HEALTH = false
+
+// describeUnhealthyTargets returns the instance ids, and the IPs of IP
+// targets, that any of the account's target groups reports as unhealthy or
+// draining. It makes a DescribeTargetHealth call per target group.
+func describeUnhealthyTargets(ctx context.Context, cfg aws.Config) (map[string]bool, error) {
+	client := elbv2.NewFromConfig(cfg)
+	unhealthy := make(map[string]bool)
+	pages := elbv2.NewDescribeTargetGroupsPaginator(client, &elbv2.DescribeTargetGroupsInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range page.TargetGroups {
+			if group.TargetType == elbv2types.TargetTypeEnumLambda || group.TargetType == elbv2types.TargetTypeEnumAlb {
+				continue
+			}
+			health, err := client.DescribeTargetHealth(ctx, &elbv2.DescribeTargetHealthInput{TargetGroupArn: group.TargetGroupArn})
+			if err != nil {
+				return nil, err
+			}
+			for _, target := range health.TargetHealthDescriptions {
+				if target.Target == nil || target.TargetHealth == nil {
+					continue
+				}
+				switch target.TargetHealth.State {
+				case elbv2types.TargetHealthStateEnumUnhealthy, elbv2types.TargetHealthStateEnumDraining, elbv2types.TargetHealthStateEnumUnhealthyDraining:
+					unhealthy[aws.ToString(target.Target.Id)] = true
+				}
+			}
+		}
+	}
+	return unhealthy, nil
+}
@@ -0,0 +1,88 @@
+package providers
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// VpcEndpoints fetches the account's interface VPC endpoints (PrivateLink)
+// and serves the IPs of their network interfaces as <service>.vpce.<domain>,
+// using the short service name (e.g. secretsmanager, ecr-api) or Name tag.
+func VpcEndpoints(ctx context.Context, cfg aws.Config, clients *cache.Clients, _ map[cache.Key][]*cache.Record) (map[cache.Key][]*cache.Record, error) {
+	records := make(map[cache.Key][]*cache.Record)
+	client := clients.EC2(cfg)
+
+	names := make(map[string][]string)
+	var interfaceIDs []string
+	endpoints := ec2.NewDescribeVpcEndpointsPaginator(client, &ec2.DescribeVpcEndpointsInput{
+		Filters: []ec2types.Filter{
+			{
+				Name:   aws.String("vpc-endpoint-type"),
+				Values: []string{string(ec2types.VpcEndpointTypeInterface)},
+			},
+		},
+	})
+	for endpoints.HasMorePages() {
+		page, err := endpoints.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, endpoint := range page.VpcEndpoints {
+			if strings.ToLower(string(endpoint.State)) != "available" {
+				continue
+			}
+			endpointNames := []string{serviceShortName(aws.ToString(endpoint.ServiceName))}
+			if name := tagValue(endpoint.Tags, "Name", ""); name != "" {
+				endpointNames = append(endpointNames, cache.Sanitize(name))
+			}
+			for _, id := range endpoint.NetworkInterfaceIds {
+				names[id] = endpointNames
+				interfaceIDs = append(interfaceIDs, id)
+			}
+		}
+	}
+	if len(interfaceIDs) == 0 {
+		return records, nil
+	}
+
+	interfaces, err := client.DescribeNetworkInterfaces(ctx, &ec2.DescribeNetworkInterfacesInput{NetworkInterfaceIds: interfaceIDs})
+	if err != nil {
+		return nil, err
+	}
+	for _, eni := range interfaces.NetworkInterfaces {
+		endpointNames := names[aws.ToString(eni.NetworkInterfaceId)]
+		record := &cache.Record{
+			Name:             endpointNames[len(endpointNames)-1] + ".vpce",
+			PrivateIP:        net.ParseIP(aws.ToString(eni.PrivateIpAddress)),
+			AvailabilityZone: aws.ToString(eni.AvailabilityZone),
+			VpcID:            aws.ToString(eni.VpcId),
+			ValidUntil:       time.Now().Add(cache.TTL),
+		}
+		for _, name := range endpointNames {
+			addRecord(records, cache.Key{LookupTag: cache.LOOKUP_VPCE, Value: name}, record)
+		}
+	}
+
+	return records, nil
+}
+
+// serviceShortName turns an endpoint service name such as
+// com.amazonaws.us-east-1.ecr.api into a label such as ecr-api.
+func serviceShortName(service string) string {
+	labels := strings.Split(service, ".")
+	// com.amazonaws.<region>.<service> or com.amazonaws.vpce.<region>.<vpce-svc-id>
+	if len(labels) > 3 && labels[0] == "com" && labels[1] == "amazonaws" {
+		labels = labels[3:]
+		if strings.HasPrefix(service, "com.amazonaws.vpce.") {
+			labels = labels[len(labels)-1:]
+		}
+	}
+	return cache.Sanitize(strings.Join(labels, "-"))
+}
@@ -0,0 +1,54 @@
+// Package awstags is a CoreDNS plugin, aws_tags, that serves the same names
+// for EC2 instances, RDS databases and the rest as the aws-name-server
+// command does, so clusters can resolve them through their existing CoreDNS
+// deployment. See README.md for the Corefile syntax.
+package awstags
+
+import (
+	"context"
+
+	awsdnsserver "github.com/foreflight/aws-name-server/pkg/dnsserver"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/fall"
+	"github.com/coredns/coredns/plugin/pkg/nonwriter"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// AWSTags answers queries for its zone from the caches of a NameServer.
+type AWSTags struct {
+	Next plugin.Handler
+	Fall fall.F
+
+	zone   string
+	server *awsdnsserver.NameServer
+}
+
+// ServeDNS implements plugin.Handler.
+func (a *AWSTags) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	state := request.Request{W: w, Req: r}
+	if plugin.Zones([]string{a.zone}).Matches(state.Name()) == "" {
+		return plugin.NextOrFailure(a.Name(), a.Next, ctx, w, r)
+	}
+
+	// zone transfers write several messages, and can't fall through
+	if state.QType() == dns.TypeAXFR || state.QType() == dns.TypeIXFR {
+		a.server.ServeDNS(w, r)
+		return dns.RcodeSuccess, nil
+	}
+
+	nw := nonwriter.New(w)
+	a.server.ServeDNS(nw, r)
+	if nw.Msg == nil {
+		return dns.RcodeServerFailure, nil
+	}
+	if nw.Msg.Rcode == dns.RcodeNameError && a.Fall.Through(state.Name()) {
+		return plugin.NextOrFailure(a.Name(), a.Next, ctx, w, r)
+	}
+	w.WriteMsg(nw.Msg)
+	return nw.Msg.Rcode, nil
+}
+
+// Name implements plugin.Handler.
+func (a *AWSTags) Name() string { return "aws_tags" }
@@ -0,0 +1,136 @@
+package awstags
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	awsnameserver "github.com/foreflight/aws-name-server"
+	"github.com/foreflight/aws-name-server/pkg/cache"
+	awsdnsserver "github.com/foreflight/aws-name-server/pkg/dnsserver"
+	_ "github.com/foreflight/aws-name-server/pkg/providers"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+)
+
+func init() { plugin.Register("aws_tags", setup) }
+
+// setup parses
+//
+//	aws_tags [ZONE] {
+//	    regions REGION...
+//	    profile PROFILE
+//	    config FILE
+//	    services SERVICE...
+//	    refresh DURATION
+//	    ttl DURATION
+//	    concurrency N
+//	    fallthrough [ZONES...]
+//	}
+//
+// and starts the caches. The refresh, ttl and services settings are global
+// to the cache package, so there should be one aws_tags per CoreDNS
+// instance.
+func setup(c *caddy.Controller) error {
+	c.Next() // aws_tags
+	zones := plugin.OriginsFromArgsOrServerBlock(c.RemainingArgs(), c.ServerBlockKeys)
+	if len(zones) != 1 {
+		return plugin.Error("aws_tags", c.Errf("serves exactly one zone, not %d", len(zones)))
+	}
+
+	a := &AWSTags{zone: zones[0]}
+	mainAccount := &cache.AWSAccount{NickName: "main", Regions: []string{cache.DEFAULT_REGION}}
+	var accounts []*cache.AWSAccount
+	var lookupTags map[string]string
+	services := []string{"ec2", "rds"}
+	concurrency := 8
+
+	for c.NextBlock() {
+		switch c.Val() {
+		case "regions":
+			if mainAccount.Regions = c.RemainingArgs(); len(mainAccount.Regions) == 0 {
+				return plugin.Error("aws_tags", c.ArgErr())
+			}
+		case "profile":
+			if !c.NextArg() {
+				return plugin.Error("aws_tags", c.ArgErr())
+			}
+			mainAccount.Profile = c.Val()
+		case "config":
+			if !c.NextArg() {
+				return plugin.Error("aws_tags", c.ArgErr())
+			}
+			config, err := awsnameserver.LoadConfig(c.Val())
+			if err != nil {
+				return plugin.Error("aws_tags", c.Errf("%s", err))
+			}
+			accounts, lookupTags = config.Accounts, config.LookupTags
+			cache.INCLUDE_TAGS, cache.EXCLUDE_TAGS = config.IncludeTags, config.ExcludeTags
+		case "services":
+			if services = c.RemainingArgs(); len(services) == 0 {
+				return plugin.Error("aws_tags", c.ArgErr())
+			}
+		case "refresh", "ttl":
+			name := c.Val()
+			if !c.NextArg() {
+				return plugin.Error("aws_tags", c.ArgErr())
+			}
+			d, err := time.ParseDuration(c.Val())
+			if err != nil || d <= 0 {
+				return plugin.Error("aws_tags", c.Errf("invalid %s %q", name, c.Val()))
+			}
+			if name == "refresh" {
+				cache.REFRESH_INTERVAL = d
+			} else {
+				cache.TTL = d
+			}
+		case "concurrency":
+			if !c.NextArg() {
+				return plugin.Error("aws_tags", c.ArgErr())
+			}
+			n, err := strconv.Atoi(c.Val())
+			if err != nil || n <= 0 {
+				return plugin.Error("aws_tags", c.Errf("invalid concurrency %q", c.Val()))
+			}
+			concurrency = n
+		case "fallthrough":
+			a.Fall.SetZonesFromArgs(c.RemainingArgs())
+		default:
+			return plugin.Error("aws_tags", c.Errf("unknown property %q", c.Val()))
+		}
+	}
+	if cache.MIN_TTL > cache.TTL {
+		cache.MIN_TTL = cache.TTL
+	}
+
+	if err := cache.SetServices(services); err != nil {
+		return plugin.Error("aws_tags", err)
+	}
+	if err := cache.AddTagLookups(lookupTags); err != nil {
+		return plugin.Error("aws_tags", err)
+	}
+
+	domain := strings.TrimSuffix(a.zone, ".")
+	caches, _, err := cache.NewCaches(append(accounts, mainAccount), domain, concurrency, nil)
+	if err != nil {
+		return plugin.Error("aws_tags", err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	a.server = awsdnsserver.NewNameServer(domain, hostname, caches)
+
+	c.OnShutdown(func() error {
+		caches.Stop()
+		return nil
+	})
+	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
+		a.Next = next
+		return a
+	})
+	return nil
+}
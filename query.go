@@ -0,0 +1,51 @@
+package awsnameserver
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"github.com/foreflight/aws-name-server/pkg/dnsserver"
+)
+
+// queryMsg builds the query for the query command's arguments, a name and
+// optionally a type, which defaults to A.
+func queryMsg(args []string) (*dns.Msg, error) {
+	qtype := dns.TypeA
+	if len(args) > 1 {
+		var ok bool
+		if qtype, ok = dns.StringToType[args[1]]; !ok {
+			return nil, fmt.Errorf("unknown query type: %s", args[1])
+		}
+	}
+	return new(dns.Msg).SetQuestion(dns.Fqdn(args[0]), qtype), nil
+}
+
+// queryRemote asks the instance at address and prints its answer.
+func queryRemote(address string, args []string) error {
+	request, err := queryMsg(args)
+	if err != nil {
+		return err
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "53")
+	}
+	response, _, err := new(dns.Client).Exchange(request, address)
+	if err != nil {
+		return err
+	}
+	fmt.Println(response)
+	return nil
+}
+
+// queryLocal answers from server's caches, as it would a query from
+// localhost, and prints the answer.
+func queryLocal(server *dnsserver.NameServer, args []string) error {
+	request, err := queryMsg(args)
+	if err != nil {
+		return err
+	}
+	fmt.Println(server.Reply(request, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}))
+	return nil
+}
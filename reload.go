@@ -0,0 +1,87 @@
+package awsnameserver
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// CONFIG_SETTLE is how long --watchConfig waits for the config file to stop
+// changing before reloading it, as it is often written in several steps.
+const CONFIG_SETTLE = time.Second
+
+// reloadOnSIGHUP reloads the accounts whenever the process gets SIGHUP.
+func reloadOnSIGHUP(configFile string, caches *cache.CacheSet, main *cache.AWSAccount) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		reload(configFile, caches, main)
+	}
+}
+
+// reloadOnChange reloads the accounts whenever configFile is written,
+// created or replaced. The directory is watched rather than the file, so
+// files replaced by renaming a new one over them are followed.
+func reloadOnChange(configFile string, caches *cache.CacheSet, main *cache.AWSAccount) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		var settle <-chan time.Time
+		for {
+			select {
+			case event := <-watcher.Events:
+				if filepath.Clean(event.Name) == filepath.Clean(configFile) && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					settle = time.After(CONFIG_SETTLE)
+				}
+			case err := <-watcher.Errors:
+				log.Printf("WARN: watching %s: %s", configFile, err)
+			case <-settle:
+				settle = nil
+				reload(configFile, caches, main)
+			}
+		}
+	}()
+	return nil
+}
+
+// reload re-reads the accounts from configFile into caches, alongside main,
+// the account given by the flags. The DNS listeners carry on serving
+// throughout, and a config file that can't be read is logged and leaves the
+// caches as they were.
+func reload(configFile string, caches *cache.CacheSet, main *cache.AWSAccount) {
+	log.Printf("Reloading accounts from %s", configFile)
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		log.Printf("ERROR: reloading: %s", err)
+		return
+	}
+	config, err := parseConfigFile(configFile, data)
+	if err != nil {
+		log.Printf("ERROR: reloading %s: %s", configFile, err)
+		return
+	}
+	// --replicate marks main Replicated, and applies to every account
+	if main.Replicated {
+		for _, account := range config.Accounts {
+			account.Replicated = true
+		}
+	}
+	if err := caches.Reload(append(config.Accounts, main)); err != nil {
+		log.Printf("ERROR: reloading %s: %s", configFile, err)
+	}
+}
@@ -0,0 +1,57 @@
+package awsnameserver
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+	"github.com/foreflight/aws-name-server/pkg/dnsserver"
+)
+
+// SerialWriter keeps the zone's SOA serial in a file, so a restarted
+// server resumes from it rather than from the clock.
+type SerialWriter struct {
+	path   string
+	server *dnsserver.NameServer
+	mutex  sync.Mutex
+	last   uint32
+}
+
+// NewSerialWriter resumes server from the serial in path, if there is one,
+// and rewrites path whenever the serial changes on a refresh of caches.
+func NewSerialWriter(path string, server *dnsserver.NameServer, caches *cache.CacheSet) *SerialWriter {
+	writer := &SerialWriter{path: path, server: server}
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		var serial uint64
+		if serial, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 32); err == nil {
+			server.ResumeSerial(uint32(serial))
+		}
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("WARN: reading serial from %s: %s", path, err)
+	}
+	caches.Subscribe(writer.write)
+	writer.write()
+	return writer
+}
+
+// write saves the current serial, unless it is already saved.
+func (writer *SerialWriter) write() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	serial := writer.server.Serial()
+	if serial == writer.last {
+		return
+	}
+	if err := writeFileAtomic(writer.path, []byte(strconv.FormatUint(uint64(serial), 10)+"\n")); err != nil {
+		log.Printf("ERROR: writing %s: %s", writer.path, err)
+		return
+	}
+	writer.last = serial
+}
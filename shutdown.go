@@ -0,0 +1,39 @@
+package awsnameserver
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+	"github.com/foreflight/aws-name-server/pkg/dnsserver"
+)
+
+// SHUTDOWN_TIMEOUT is how long in-flight queries get to finish after
+// SIGTERM before the server exits anyway.
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
+// waitForShutdown blocks until the process gets SIGTERM or SIGINT, then
+// stops refreshing caches, gives up the leader lock and leaves the gossip
+// cluster if there are any, and shuts the listeners down gracefully.
+func waitForShutdown(server *dnsserver.NameServer, caches *cache.CacheSet, elector *Elector, gossip *Gossip) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	log.Printf("Shutting down on %s", sig)
+	notifyStopping()
+
+	caches.Stop()
+	if elector != nil {
+		elector.Resign()
+	}
+	if gossip != nil {
+		gossip.Leave()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	server.Shutdown(ctx)
+}
@@ -0,0 +1,65 @@
+package awsnameserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// SnapshotWriter writes the caches to a snapshot file whenever they change.
+type SnapshotWriter struct {
+	path   string
+	caches *cache.CacheSet
+	mutex  sync.Mutex
+}
+
+// NewSnapshotWriter creates a SnapshotWriter that rewrites path after
+// every refresh of caches.
+func NewSnapshotWriter(path string, caches *cache.CacheSet) *SnapshotWriter {
+	writer := &SnapshotWriter{path: path, caches: caches}
+	caches.Subscribe(writer.write)
+	return writer
+}
+
+// write replaces the snapshot file, via a temporary file so it is never
+// left half written.
+func (writer *SnapshotWriter) write() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	data, err := json.Marshal(writer.caches.Snapshot())
+	if err == nil {
+		err = writeFileAtomic(writer.path, data)
+	}
+	if err != nil {
+		log.Printf("ERROR: writing %s: %s", writer.path, err)
+	}
+}
+
+// writeFileAtomic replaces the file at path with data, via a temporary file
+// so it is never left half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
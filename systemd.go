@@ -0,0 +1,73 @@
+package awsnameserver
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+
+	"github.com/foreflight/aws-name-server/pkg/cache"
+)
+
+// WATCHDOG_STALENESS is how long every cache can go without a successful
+// refresh, as when the credentials have expired, before the watchdog stops
+// being pinged and systemd restarts the server.
+const WATCHDOG_STALENESS = 15 * time.Minute
+
+// notifyReady tells systemd the server is up, for Type=notify units, and
+// pings its watchdog for as long as caches are healthy if WatchdogSec is
+// set. It does nothing when not run by systemd.
+func notifyReady(caches *cache.CacheSet) {
+	if _, err := daemon.SdNotify(false, daemon.SdNotifyReady); err != nil {
+		log.Printf("WARN: sd_notify: %s", err)
+	}
+
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		log.Printf("WARN: sd_notify: %s", err)
+	}
+	if interval <= 0 {
+		return
+	}
+	log.Printf("Pinging the systemd watchdog every %s", interval/2)
+	go func() {
+		for range time.Tick(interval / 2) {
+			if err := healthy(caches.All()); err != nil {
+				log.Printf("ERROR: not pinging the systemd watchdog: %s", err)
+				daemon.SdNotify(false, "STATUS="+err.Error())
+				continue
+			}
+			daemon.SdNotify(false, daemon.SdNotifyWatchdog)
+		}
+	}()
+}
+
+// notifyStopping tells systemd the server is shutting down.
+func notifyStopping() {
+	daemon.SdNotify(false, daemon.SdNotifyStopping)
+}
+
+// healthy returns an error if a cache's refreshes have wedged, so it
+// hasn't finished one in longer than the longest backoff, or if every cache
+// has been stale for WATCHDOG_STALENESS. Replicated caches never refresh,
+// so only their staleness counts.
+func healthy(caches []*cache.Cache) error {
+	stale := 0
+	for _, c := range caches {
+		if c.Staleness() > WATCHDOG_STALENESS {
+			stale++
+		}
+		if c.Account().Replicated {
+			continue
+		}
+		limit := c.RefreshInterval() + cache.MAX_BACKOFF + 2*cache.REFRESH_TIMEOUT
+		if since := time.Since(c.Attempted()); since > limit {
+			return fmt.Errorf("%s account in %s hasn't finished a refresh in %s", c.Nickname(), c.Account().Region, since.Round(time.Second))
+		}
+	}
+	if len(caches) > 0 && stale == len(caches) {
+		return fmt.Errorf("every account has been stale for over %s", WATCHDOG_STALENESS)
+	}
+	return nil
+}
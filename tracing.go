@@ -0,0 +1,36 @@
+package awsnameserver
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// setupTracing exports the query and refresh spans to the OTLP/HTTP
+// collector at endpoint, e.g. http://localhost:4318. Until it is called
+// they are no-ops, so spans cost nothing when --otlpEndpoint is unset. The
+// usual OTEL_EXPORTER_OTLP_* and OTEL_TRACES_SAMPLER environment variables
+// are honoured too.
+func setupTracing(ctx context.Context, endpoint string) error {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "aws-name-server"),
+	))
+	if err != nil {
+		return err
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return nil
+}